@@ -0,0 +1,481 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// This file contains the `-cleanup-checkpoints` mode. Before the checkpoint
+// files are deleted or archived, the checkpoint directory is scanned to
+// report what the cleanup is going to touch. With `-dry-run` only the report
+// is produced. It also contains the cleanup of the checkpoints of clusters
+// with a `retention-per-cluster`, which is run periodically as well.
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	ccconf "github.com/ClusterCockpit/cc-lib/v2/ccConfig"
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
+)
+
+// stagingPrefix is the prefix of the directories in which the checkpoints of
+// a cluster are collected for archiving.
+const stagingPrefix = ".cleanup-"
+
+// checkpointStats summarizes a set of checkpoint files.
+type checkpointStats struct {
+	Files  int   `json:"files"`
+	Bytes  int64 `json:"bytes"`
+	Oldest int64 `json:"oldest,omitempty"` // Unix timestamp of the oldest checkpoint
+	Newest int64 `json:"newest,omitempty"` // Unix timestamp of the newest checkpoint
+}
+
+func (s *checkpointStats) add(ts, size int64) {
+	if s.Files == 0 || ts < s.Oldest {
+		s.Oldest = ts
+	}
+	if s.Files == 0 || ts > s.Newest {
+		s.Newest = ts
+	}
+	s.Files++
+	s.Bytes += size
+}
+
+func (s *checkpointStats) merge(o checkpointStats) {
+	if o.Files == 0 {
+		return
+	}
+	if s.Files == 0 || o.Oldest < s.Oldest {
+		s.Oldest = o.Oldest
+	}
+	if s.Files == 0 || o.Newest > s.Newest {
+		s.Newest = o.Newest
+	}
+	s.Files += o.Files
+	s.Bytes += o.Bytes
+}
+
+// checkpointUsage holds all checkpoints found and the subset the cleanup
+// deletes or archives.
+type checkpointUsage struct {
+	Total    checkpointStats `json:"total"`
+	Affected checkpointStats `json:"affected"`
+}
+
+func (u *checkpointUsage) merge(o checkpointUsage) {
+	u.Total.merge(o.Total)
+	u.Affected.merge(o.Affected)
+}
+
+type hostCheckpoints struct {
+	Host string `json:"host"`
+	checkpointUsage
+	Error string `json:"error,omitempty"`
+}
+
+type clusterCheckpoints struct {
+	Cluster string `json:"cluster"`
+	// Checkpoints up to this timestamp are affected as well because of the
+	// retention-per-cluster of the cluster
+	Before int64 `json:"before,omitempty"`
+	checkpointUsage
+	Hosts []hostCheckpoints `json:"hosts"`
+}
+
+// cleanupReport is written by `-cleanup-report` for maintenance scripts.
+type cleanupReport struct {
+	Action        string `json:"action"` // "delete" or "archive"
+	DryRun        bool   `json:"dry-run"`
+	CheckpointDir string `json:"checkpoint-directory"`
+	ArchiveDir    string `json:"archive-directory,omitempty"`
+	Before        int64  `json:"before"` // Checkpoints up to this timestamp are affected
+	checkpointUsage
+	Clusters  []clusterCheckpoints `json:"clusters"`
+	Processed int                  `json:"processed"` // Files deleted or archived
+	Error     string               `json:"error,omitempty"`
+}
+
+// scanCheckpoints walks `<dir>/<cluster>/<host>/` and collects the checkpoint
+// files. The files selected by expiredCheckpoints for before and the cutoff
+// of the cluster in clusterBefore are affected.
+func scanCheckpoints(dir string, before int64, clusterBefore map[string]int64) ([]clusterCheckpoints, error) {
+	clusterEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	clusters := []clusterCheckpoints{}
+	for _, ce := range clusterEntries {
+		if !ce.IsDir() {
+			continue
+		}
+
+		cluster := clusterCheckpoints{Cluster: ce.Name(), Before: clusterBefore[ce.Name()], Hosts: []hostCheckpoints{}}
+		hostEntries, err := os.ReadDir(filepath.Join(dir, ce.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, he := range hostEntries {
+			if !he.IsDir() || strings.HasPrefix(he.Name(), stagingPrefix) {
+				continue
+			}
+
+			host := hostCheckpoints{Host: he.Name()}
+			if err := scanHostCheckpoints(filepath.Join(dir, ce.Name(), he.Name()), before, cluster.Before, &host.checkpointUsage); err != nil {
+				host.Error = err.Error()
+			}
+			cluster.merge(host.checkpointUsage)
+			cluster.Hosts = append(cluster.Hosts, host)
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	return clusters, nil
+}
+
+func scanHostCheckpoints(dir string, before, clusterBefore int64, usage *checkpointUsage) error {
+	files, err := listCheckpoints(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		usage.Total.add(f.ts, f.size)
+	}
+	for _, f := range expiredCheckpoints(files, before, clusterBefore) {
+		usage.Affected.add(f.ts, f.size)
+	}
+	return nil
+}
+
+// checkpointFile is a checkpoint file of a host.
+type checkpointFile struct {
+	name     string
+	ts, size int64
+}
+
+// listCheckpoints returns the checkpoint files in dir, sorted by timestamp.
+func listCheckpoints(dir string) ([]checkpointFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []checkpointFile{}
+	for _, e := range entries {
+		name, ext := e.Name(), filepath.Ext(e.Name())
+		if ext != ".json" && ext != ".bin" {
+			continue
+		}
+
+		ts, err := strconv.ParseInt(strings.TrimSuffix(name, ext), 10, 64)
+		if err != nil {
+			// metricstore.CleanupCheckpoints skips the whole host in this case.
+			return nil, fmt.Errorf("unexpected checkpoint file %q", name)
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, checkpointFile{name: name, ts: ts, size: info.Size()})
+	}
+
+	slices.SortFunc(files, func(a, b checkpointFile) int { return cmp.Compare(a.ts, b.ts) })
+	return files, nil
+}
+
+// expiredCheckpoints returns the files of a host that are deleted or
+// archived. metricstore.CleanupCheckpoints takes the files up to before.
+// A file holds the data from its timestamp up to the timestamp of the next
+// one, and a retention-per-cluster may be shorter than the checkpoint
+// interval, so for clusterBefore a file is only taken once the next file
+// starts up to clusterBefore as well.
+func expiredCheckpoints(files []checkpointFile, before, clusterBefore int64) []checkpointFile {
+	expired := []checkpointFile{}
+	for i, f := range files {
+		if f.ts == 0 {
+			continue
+		}
+		if f.ts <= before || (i+1 < len(files) && files[i+1].ts <= clusterBefore) {
+			expired = append(expired, f)
+		}
+	}
+	return expired
+}
+
+// cleanupClusterCheckpoints deletes or archives the checkpoints of cluster
+// selected by expiredCheckpoints for before. metricstore.CleanupCheckpoints
+// handles all clusters at once and cannot be limited to a part of the
+// files, so for archiving, the files are linked into a staging directory
+// inside the cluster directory (so that it is on the same file system),
+// which is archived instead. The originals of the files archived are
+// deleted afterwards.
+func cleanupClusterCheckpoints(root, archiveDir, cluster string, before int64, deleteMode bool) (int, error) {
+	clusterDir := filepath.Join(root, cluster)
+	hostEntries, err := os.ReadDir(clusterDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	// Staging directories left by an interrupted run
+	for _, he := range hostEntries {
+		if strings.HasPrefix(he.Name(), stagingPrefix) {
+			os.RemoveAll(filepath.Join(clusterDir, he.Name()))
+		}
+	}
+
+	stage := ""
+	if !deleteMode {
+		if stage, err = os.MkdirTemp(clusterDir, stagingPrefix); err != nil {
+			return 0, err
+		}
+		defer os.RemoveAll(stage)
+	}
+
+	n, errs := 0, []error{}
+	staged := map[string]string{} // Link in the staging directory to original
+	for _, he := range hostEntries {
+		if !he.IsDir() || strings.HasPrefix(he.Name(), stagingPrefix) {
+			continue
+		}
+
+		dir := filepath.Join(clusterDir, he.Name())
+		files, err := listCheckpoints(dir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s/%s: %w", cluster, he.Name(), err))
+			continue
+		}
+		for _, f := range expiredCheckpoints(files, 0, before) {
+			path := filepath.Join(dir, f.name)
+			if deleteMode {
+				if err := os.Remove(path); err != nil {
+					errs = append(errs, err)
+				} else {
+					n++
+				}
+				continue
+			}
+
+			link := filepath.Join(stage, cluster, he.Name(), f.name)
+			if err := os.MkdirAll(filepath.Dir(link), 0o755); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if err := os.Link(path, link); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			staged[link] = path
+		}
+	}
+
+	if len(staged) > 0 {
+		if _, err := metricstore.CleanupCheckpoints(stage, archiveDir, before, false); err != nil {
+			errs = append(errs, err)
+		}
+		// metricstore.CleanupCheckpoints deletes the files it archived.
+		for link, path := range staged {
+			if _, err := os.Lstat(link); !errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+			} else {
+				n++
+			}
+		}
+	}
+
+	return n, errors.Join(errs...)
+}
+
+// clusterCutoffs returns the timestamps up to which the checkpoints of the
+// clusters with a retention-per-cluster expire at now.
+func clusterCutoffs(now time.Time) map[string]int64 {
+	cutoffs := map[string]int64{}
+	for cluster, d := range config.ClusterRetention() {
+		cutoffs[cluster] = now.Add(-d).Unix()
+	}
+	return cutoffs
+}
+
+func formatTimestamp(ts int64) string {
+	if ts == 0 {
+		return "-"
+	}
+	return time.Unix(ts, 0).Format(time.RFC3339)
+}
+
+// printCleanupReport writes a human readable summary of a dry run with one
+// line per host.
+func printCleanupReport(w io.Writer, r *cleanupReport) error {
+	fmt.Fprintf(w, "Checkpoints in %s up to %s would be %sd:\n",
+		r.CheckpointDir, formatTimestamp(r.Before), r.Action)
+	for _, c := range r.Clusters {
+		if c.Before != 0 {
+			fmt.Fprintf(w, "  %s: up to %s (retention-per-cluster)\n", c.Cluster, formatTimestamp(c.Before))
+		}
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "CLUSTER\tHOST\tFILES\tBYTES\tOLDEST\tNEWEST\t%s FILES\t%s BYTES\t\n",
+		strings.ToUpper(r.Action), strings.ToUpper(r.Action))
+	line := func(cluster, host string, u checkpointUsage) {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%d\t%d\t\n", cluster, host,
+			u.Total.Files, u.Total.Bytes, formatTimestamp(u.Total.Oldest), formatTimestamp(u.Total.Newest),
+			u.Affected.Files, u.Affected.Bytes)
+	}
+
+	errs := []string{}
+	for _, c := range r.Clusters {
+		for _, h := range c.Hosts {
+			if h.Error != "" {
+				errs = append(errs, fmt.Sprintf("%s/%s: %s", c.Cluster, h.Host, h.Error))
+			}
+			line(c.Cluster, h.Host, h.checkpointUsage)
+		}
+		line(c.Cluster, "(all)", c.checkpointUsage)
+	}
+	line("(all)", "(all)", r.checkpointUsage)
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(errs) > 0 {
+		fmt.Fprintf(w, "\nHosts skipped by the cleanup:\n  %s\n", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+func writeCleanupReport(path string, r *cleanupReport) error {
+	if path == "" {
+		return nil
+	}
+
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("creating cleanup report: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return fmt.Errorf("writing cleanup report: %w", err)
+	}
+	return nil
+}
+
+// cleanupCheckpoints deletes or archives the checkpoints older than
+// `retention-in-memory` and those of clusters older than their
+// `retention-per-cluster`, or only reports them if flagDryRun is set.
+func cleanupCheckpoints() error {
+	mscfg := ccconf.GetPackageConfig("metric-store")
+	if mscfg == nil {
+		return fmt.Errorf("metric-store configuration required for checkpoint cleanup")
+	}
+	mscfg = config.InitRetention(mscfg)
+	if err := json.Unmarshal(mscfg, &metricstore.Keys); err != nil {
+		return fmt.Errorf("decoding metric-store config: %w", err)
+	}
+	// metricstore.Init is not called in this mode, apply its default here.
+	if metricstore.Keys.NumWorkers <= 0 {
+		metricstore.Keys.NumWorkers = min(runtime.NumCPU()/2+1, metricstore.DefaultMaxWorkers)
+	}
+	d, err := time.ParseDuration(metricstore.Keys.RetentionInMemory)
+	if err != nil {
+		return fmt.Errorf("parsing retention-in-memory: %w", err)
+	}
+	now := time.Now()
+	from := now.Add(-d)
+	cutoffs := clusterCutoffs(now)
+	deleteMode := metricstore.Keys.Cleanup == nil || metricstore.Keys.Cleanup.Mode != "archive"
+	cleanupDir := ""
+	if !deleteMode {
+		cleanupDir = metricstore.Keys.Cleanup.RootDir
+	}
+
+	report := &cleanupReport{
+		Action:        "delete",
+		DryRun:        flagDryRun,
+		CheckpointDir: metricstore.Keys.Checkpoints.RootDir,
+		ArchiveDir:    cleanupDir,
+		Before:        from.Unix(),
+	}
+	if !deleteMode {
+		report.Action = "archive"
+	}
+
+	report.Clusters, err = scanCheckpoints(report.CheckpointDir, report.Before, cutoffs)
+	if err != nil {
+		return fmt.Errorf("scanning checkpoints: %w", err)
+	}
+	for _, c := range report.Clusters {
+		report.merge(c.checkpointUsage)
+	}
+
+	if flagDryRun {
+		if flagCleanupReport != "-" {
+			if err := printCleanupReport(os.Stdout, report); err != nil {
+				return err
+			}
+		}
+		return writeCleanupReport(flagCleanupReport, report)
+	}
+
+	cclog.Infof("Cleaning up checkpoints older than %s (%d files, %d bytes)...",
+		from.Format(time.RFC3339), report.Affected.Files, report.Affected.Bytes)
+	n, err := metricstore.CleanupCheckpoints(
+		metricstore.Keys.Checkpoints.RootDir, cleanupDir, from.Unix(), deleteMode,
+	)
+	errs := []error{err}
+	for _, cluster := range slices.Sorted(maps.Keys(cutoffs)) {
+		cclog.Infof("Cleaning up checkpoints of cluster '%s' older than %s...",
+			cluster, time.Unix(cutoffs[cluster], 0).Format(time.RFC3339))
+		m, err := cleanupClusterCheckpoints(
+			metricstore.Keys.Checkpoints.RootDir, cleanupDir, cluster, cutoffs[cluster], deleteMode,
+		)
+		n += m
+		errs = append(errs, err)
+	}
+	report.Processed = n
+	if err := errors.Join(errs...); err != nil {
+		report.Error = err.Error()
+		if rerr := writeCleanupReport(flagCleanupReport, report); rerr != nil {
+			cclog.Error(rerr.Error())
+		}
+		return fmt.Errorf("checkpoint cleanup: %w", err)
+	}
+	if deleteMode {
+		cclog.Printf("Cleanup done: %d checkpoint files deleted.", n)
+	} else {
+		cclog.Printf("Cleanup done: %d checkpoint files archived to parquet.", n)
+	}
+	return writeCleanupReport(flagCleanupReport, report)
+}
@@ -0,0 +1,136 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+)
+
+// writeCheckpoints creates JSON checkpoint files with the timestamps ts in
+// `<root>/<cluster>/<host>/`.
+func writeCheckpoints(t *testing.T, root, cluster, host string, ts ...int64) {
+	t.Helper()
+	dir := filepath.Join(root, cluster, host)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, from := range ts {
+		cf := fmt.Sprintf(`{"from": %d, "to": %d, "metrics": {"cpu_load": {"frequency": 60, "start": %d, "data": [1, 2]}}, "children": {}}`,
+			from, from+100, from)
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.json", from)), []byte(cf), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkpointNames(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := listCheckpoints(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.name)
+	}
+	return names
+}
+
+func TestExpiredCheckpoints(t *testing.T) {
+	files := []checkpointFile{{name: "0.bin"}, {name: "100.bin", ts: 100}, {name: "200.bin", ts: 200}, {name: "300.bin", ts: 300}}
+	tests := []struct {
+		before, clusterBefore int64
+		want                  []string
+	}{
+		{0, 0, []string{}},
+		{200, 0, []string{"100.bin", "200.bin"}},
+		// 200.bin holds the data up to 300.
+		{0, 250, []string{"100.bin"}},
+		{0, 300, []string{"100.bin", "200.bin"}},
+		// The newest file is never taken for the cluster retention.
+		{0, 1000, []string{"100.bin", "200.bin"}},
+		{300, 150, []string{"100.bin", "200.bin", "300.bin"}},
+	}
+	for _, tt := range tests {
+		names := []string{}
+		for _, f := range expiredCheckpoints(files, tt.before, tt.clusterBefore) {
+			names = append(names, f.name)
+		}
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("expiredCheckpoints(%d, %d) = %v, want %v", tt.before, tt.clusterBefore, names, tt.want)
+		}
+	}
+}
+
+func TestScanCheckpoints(t *testing.T) {
+	root := t.TempDir()
+	writeCheckpoints(t, root, "fritz", "f0101", 100, 200, 300)
+	writeCheckpoints(t, root, "alex", "a0101", 100, 200, 300)
+	if err := os.MkdirAll(filepath.Join(root, "alex", stagingPrefix+"1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	clusters, err := scanCheckpoints(root, 150, map[string]int64{"alex": 300})
+	if err != nil {
+		t.Fatalf("scanCheckpoints: %v", err)
+	}
+	affected := map[string]int{}
+	for _, c := range clusters {
+		if len(c.Hosts) != 1 || c.Total.Files != 3 {
+			t.Errorf("cluster %s: %+v", c.Cluster, c)
+		}
+		affected[c.Cluster] = c.Affected.Files
+	}
+	if affected["fritz"] != 1 || affected["alex"] != 2 {
+		t.Errorf("affected files %v", affected)
+	}
+}
+
+func TestCleanupClusterCheckpoints(t *testing.T) {
+	metricstore.Keys.NumWorkers = 2
+
+	for _, deleteMode := range []bool{true, false} {
+		root, archive := t.TempDir(), t.TempDir()
+		writeCheckpoints(t, root, "fritz", "f0101", 100, 200, 300)
+		writeCheckpoints(t, root, "fritz", "f0102", 100, 200)
+		writeCheckpoints(t, root, "alex", "a0101", 100, 200, 300)
+
+		n, err := cleanupClusterCheckpoints(root, archive, "fritz", 250, deleteMode)
+		if err != nil || n != 2 {
+			t.Errorf("delete %v: cleanupClusterCheckpoints = %d, %v", deleteMode, n, err)
+		}
+
+		want := map[string][]string{
+			"fritz/f0101": {"200.json", "300.json"},
+			"fritz/f0102": {"200.json"},
+			"alex/a0101":  {"100.json", "200.json", "300.json"},
+		}
+		for dir, names := range want {
+			if got := checkpointNames(t, filepath.Join(root, dir)); !slices.Equal(got, names) {
+				t.Errorf("delete %v: %s holds %v, want %v", deleteMode, dir, got, names)
+			}
+		}
+		if entries, _ := os.ReadDir(filepath.Join(root, "fritz")); len(entries) != 2 {
+			t.Errorf("delete %v: staging directory left behind: %v", deleteMode, entries)
+		}
+
+		_, err = os.Stat(filepath.Join(archive, "fritz", "250.parquet"))
+		if deleteMode != os.IsNotExist(err) {
+			t.Errorf("delete %v: parquet archive: %v", deleteMode, err)
+		}
+	}
+
+	// Clusters without checkpoints are skipped.
+	if n, err := cleanupClusterCheckpoints(t.TempDir(), "", "fritz", 250, true); n != 0 || err != nil {
+		t.Errorf("missing cluster: %d, %v", n, err)
+	}
+}
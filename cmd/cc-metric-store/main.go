@@ -7,7 +7,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -15,7 +14,6 @@ import (
 	"runtime/debug"
 	"sync"
 	"syscall"
-	"time"
 
 	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
 	ccconf "github.com/ClusterCockpit/cc-lib/v2/ccConfig"
@@ -38,8 +36,8 @@ var (
 )
 
 var (
-	flagGops, flagVersion, flagDev, flagLogDateTime, flagCleanupCheckpoints bool
-	flagConfigFile, flagLogLevel                                            string
+	flagGops, flagVersion, flagDev, flagLogDateTime, flagCleanupCheckpoints, flagDryRun bool
+	flagConfigFile, flagLogLevel, flagCleanupReport                                     string
 )
 
 func printVersion() {
@@ -68,14 +66,26 @@ func runServer(ctx context.Context) error {
 		debug.SetGCPercent(15)
 	}
 
+	mscfg = config.InitRetention(mscfg)
+	config.InitRegistry()
 	metricstore.Init(mscfg, config.GetMetrics(), &wg)
 
-	if config.Keys.BackendURL != "" {
-		ms := metricstore.GetMemoryStore()
-		ms.SetNodeProvider(api.NewBackendNodeProvider(config.Keys.BackendURL))
-		cclog.Infof("Node provider configured with backend URL: %s", config.Keys.BackendURL)
+	provider, err := api.NewNodeProvider(config.Keys.NodeProviders)
+	if err != nil {
+		return fmt.Errorf("configuring node provider: %w", err)
+	}
+	if provider != nil {
+		metricstore.GetMemoryStore().SetNodeProvider(provider)
+		cclog.Infof("Node provider configured: %T", provider)
 	}
 
+	// The workers of this package are stopped before the server and the
+	// memory store are shut down.
+	workersCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+	clusterRetention(workersCtx, &wg, provider)
+	api.TrackMemoryStoreSize(workersCtx, &wg)
+
 	// Initialize HTTP server
 	srv, err := NewServer(version, commit, date)
 	if err != nil {
@@ -105,9 +115,22 @@ func runServer(ctx context.Context) error {
 		}
 
 		runtime.SystemdNotify(false, "Shutting down ...")
+		stopWorkers()
 		srv.Shutdown(ctx)
 	}()
 
+	// Reload the metric configuration on SIGHUP
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	go func() {
+		for range hups {
+			cclog.Info("SIGHUP received, reloading metric configuration")
+			if _, err := config.ReloadMetrics(metricstore.GetMemoryStore()); err != nil {
+				cclog.Errorf("reloading metric configuration failed: %s", err.Error())
+			}
+		}
+	}()
+
 	runtime.SystemdNotify(true, "running")
 
 	// Wait for completion or errors
@@ -131,6 +154,8 @@ func run() error {
 	flag.BoolVar(&flagVersion, "version", false, "Show version information and exit")
 	flag.BoolVar(&flagLogDateTime, "logdate", false, "Set this flag to add date and time to log messages")
 	flag.BoolVar(&flagCleanupCheckpoints, "cleanup-checkpoints", false, "Clean up old checkpoint files (delete or archive) based on retention settings, then exit")
+	flag.BoolVar(&flagDryRun, "dry-run", false, "With -cleanup-checkpoints: only report which checkpoint files would be deleted or archived")
+	flag.StringVar(&flagCleanupReport, "cleanup-report", "", "With -cleanup-checkpoints: write a JSON report to this `file` (- for stdout)")
 	flag.StringVar(&flagConfigFile, "config", "./config.json", "Specify alternative path to `config.json`")
 	flag.StringVar(&flagLogLevel, "loglevel", "warn", "Sets the logging level: `[debug, info, warn (default), err, crit]`")
 	flag.Parse()
@@ -150,6 +175,7 @@ func run() error {
 	}
 
 	config.Init(cfg)
+	config.File = flagConfigFile
 
 	if flagGops || config.Keys.Debug.EnableGops {
 		if err := agent.Listen(agent.Options{}); err != nil {
@@ -158,36 +184,7 @@ func run() error {
 	}
 
 	if flagCleanupCheckpoints {
-		mscfg := ccconf.GetPackageConfig("metric-store")
-		if mscfg == nil {
-			return fmt.Errorf("metric-store configuration required for checkpoint cleanup")
-		}
-		if err := json.Unmarshal(mscfg, &metricstore.Keys); err != nil {
-			return fmt.Errorf("decoding metric-store config: %w", err)
-		}
-		d, err := time.ParseDuration(metricstore.Keys.RetentionInMemory)
-		if err != nil {
-			return fmt.Errorf("parsing retention-in-memory: %w", err)
-		}
-		from := time.Now().Add(-d)
-		deleteMode := metricstore.Keys.Cleanup == nil || metricstore.Keys.Cleanup.Mode != "archive"
-		cleanupDir := ""
-		if !deleteMode {
-			cleanupDir = metricstore.Keys.Cleanup.RootDir
-		}
-		cclog.Infof("Cleaning up checkpoints older than %s...", from.Format(time.RFC3339))
-		n, err := metricstore.CleanupCheckpoints(
-			metricstore.Keys.Checkpoints.RootDir, cleanupDir, from.Unix(), deleteMode,
-		)
-		if err != nil {
-			return fmt.Errorf("checkpoint cleanup: %w", err)
-		}
-		if deleteMode {
-			cclog.Printf("Cleanup done: %d checkpoint files deleted.", n)
-		} else {
-			cclog.Printf("Cleanup done: %d checkpoint files archived to parquet.", n)
-		}
-		return nil
+		return cleanupCheckpoints()
 	}
 
 	natsConfig := ccconf.GetPackageConfig("nats")
@@ -0,0 +1,111 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// This file contains the retention of clusters with a `retention-per-cluster`
+// shorter than `retention-in-memory`, which the memory store applies to all
+// clusters, for the buffers in memory as well as for the checkpoints.
+
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
+)
+
+// clusterRetention starts a goroutine freeing the buffers of the clusters
+// with a retention of their own. Like the retention of the memory store, it
+// runs at half the (shortest) retention, and the nodes reported by provider
+// as used by running jobs are kept. It also runs once right away, to free
+// the data restored from checkpoints, which are loaded for the whole
+// `retention-in-memory`. The checkpoints of these clusters are deleted or
+// archived at the (shortest) retention, like the cleanup of the memory store
+// does at `retention-in-memory`.
+func clusterRetention(ctx context.Context, wg *sync.WaitGroup, provider metricstore.NodeProvider) {
+	retentions := config.ClusterRetention()
+	if len(retentions) == 0 {
+		return
+	}
+
+	ms := metricstore.GetMemoryStore()
+	shortest := slices.Min(slices.Collect(maps.Values(retentions)))
+	wg.Go(func() {
+		freeClusters(ms, provider, retentions)
+
+		ticker := time.NewTicker(shortest / 2)
+		defer ticker.Stop()
+		cleanupTicker := time.NewTicker(shortest)
+		defer cleanupTicker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				freeClusters(ms, provider, retentions)
+			case <-cleanupTicker.C:
+				cleanupClusters()
+			}
+		}
+	})
+}
+
+// cleanupClusters deletes or archives the checkpoints of the clusters with
+// a retention of their own, according to the cleanup mode of the memory
+// store.
+func cleanupClusters() {
+	root := metricstore.Keys.Checkpoints.RootDir
+	if root == "" {
+		return
+	}
+	deleteMode := metricstore.Keys.Cleanup == nil || metricstore.Keys.Cleanup.Mode != "archive"
+	archiveDir, action := "", "deleted"
+	if !deleteMode {
+		archiveDir, action = metricstore.Keys.Cleanup.RootDir, "archived"
+	}
+
+	for cluster, before := range clusterCutoffs(time.Now()) {
+		n, err := cleanupClusterCheckpoints(root, archiveDir, cluster, before, deleteMode)
+		if err != nil {
+			cclog.Errorf("Retention of cluster '%s': checkpoint cleanup failed: %s", cluster, err.Error())
+		}
+		cclog.Infof("Retention of cluster '%s': %d checkpoint files %s (older than %s)",
+			cluster, n, action, time.Unix(before, 0).Format(time.RFC3339))
+	}
+}
+
+func freeClusters(ms *metricstore.MemoryStore, provider metricstore.NodeProvider, retentions map[string]time.Duration) {
+	for cluster, d := range retentions {
+		t := time.Now().Add(-d)
+
+		var used []string
+		if provider != nil {
+			nodes, err := provider.GetUsedNodes(t.Unix())
+			if err != nil {
+				cclog.Errorf("Retention of cluster '%s': querying used nodes failed: %s", cluster, err.Error())
+				continue
+			}
+			used = nodes[cluster]
+		}
+
+		freed := 0
+		for _, host := range ms.ListChildren([]string{cluster}) {
+			if slices.Contains(used, host) {
+				continue
+			}
+			n, err := ms.Free([]string{cluster, host}, t.Unix())
+			if err != nil {
+				cclog.Errorf("Retention of cluster '%s': freeing buffers of host '%s' failed: %s", cluster, host, err.Error())
+			}
+			freed += n
+		}
+		cclog.Infof("Retention of cluster '%s': %d buffers freed (older than %s)", cluster, freed, t.Format(time.RFC3339))
+	}
+}
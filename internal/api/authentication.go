@@ -8,10 +8,13 @@ package api
 import (
 	"crypto/ed25519"
 	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
 
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
 	"github.com/golang-jwt/jwt/v4"
 )
 
@@ -19,13 +22,97 @@ import (
 // Reaching it triggers eviction of expired entries (see authHandler).
 const maxTokenCacheSize = 1024
 
-func authHandler(next http.Handler, publicKey ed25519.PublicKey) http.Handler {
+// defaultRouteRoles is the authorization policy applied when the `route-roles`
+// option in the main config does not mention an endpoint. A token must carry
+// at least one of the listed roles. Destructive and state-dumping endpoints
+// are restricted to administrators.
+var defaultRouteRoles = map[string][]string{
+	"query":       {"admin", "api"},
+	"write":       {"admin", "api"},
+	"healthcheck": {"admin", "api"},
+	"metrics":     {"admin", "api"},
+	"free":        {"admin"},
+	"debug":       {"admin"},
+	"reload":      {"admin"},
+}
+
+// routeRoles returns the normalized list of roles allowed to access the
+// endpoint named route. An empty result allows any validly-signed token.
+func routeRoles(route string) []string {
+	roles, ok := config.Keys.RouteRoles[route]
+	if !ok {
+		roles = defaultRouteRoles[route]
+	}
+
+	normalized := make([]string, 0, len(roles))
+	for _, r := range roles {
+		normalized = append(normalized, normalizeRole(r))
+	}
+	return normalized
+}
+
+// normalizeRole maps the different spellings used for roles in JWTs
+// (`ROLE_ADMIN` in older tokens, `admin` in tokens minted by cc-backend)
+// onto the lowercase form.
+func normalizeRole(role string) string {
+	return strings.TrimPrefix(strings.ToLower(role), "role_")
+}
+
+// tokenRoles extracts the normalized `roles` claim from a parsed token.
+func tokenRoles(token *jwt.Token) []string {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil
+	}
+
+	rawroles, ok := claims["roles"].([]any)
+	if !ok {
+		return nil
+	}
+
+	roles := make([]string, 0, len(rawroles))
+	for _, rr := range rawroles {
+		if r, ok := rr.(string); ok {
+			roles = append(roles, normalizeRole(r))
+		}
+	}
+	return roles
+}
+
+// authorize reports whether the token carries at least one of the allowed
+// roles. An empty allowed list permits every token.
+func authorize(token *jwt.Token, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	for _, r := range tokenRoles(token) {
+		if slices.Contains(allowed, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// denyAccess answers a request whose token lacks the required role.
+func denyAccess(rw http.ResponseWriter, r *http.Request, allowed []string) {
+	authFailures.inc("forbidden")
+	handleError(fmt.Errorf("access to %s %s requires one of the roles %v", r.Method, r.URL.Path, allowed),
+		http.StatusForbidden, rw)
+}
+
+// authHandler verifies the Ed25519-signed JWT of each request and checks that
+// its `roles` claim grants access to the endpoint (see routeRoles). Requests
+// without a valid token are answered with 401, requests whose token lacks the
+// required role with 403.
+func authHandler(next http.Handler, publicKey ed25519.PublicKey, allowedRoles []string) http.Handler {
 	cacheLock := sync.RWMutex{}
 	cache := map[string]*jwt.Token{}
 
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		authheader := r.Header.Get("Authorization")
 		if authheader == "" || !strings.HasPrefix(authheader, "Bearer ") {
+			authFailures.inc("unauthorized")
 			http.Error(rw, "Use JWT Authentication", http.StatusUnauthorized)
 			return
 		}
@@ -35,6 +122,10 @@ func authHandler(next http.Handler, publicKey ed25519.PublicKey) http.Handler {
 		token, ok := cache[rawtoken]
 		cacheLock.RUnlock()
 		if ok && token.Claims.Valid() == nil {
+			if !authorize(token, allowedRoles) {
+				denyAccess(rw, r, allowedRoles)
+				return
+			}
 			next.ServeHTTP(rw, r)
 			return
 		}
@@ -42,13 +133,16 @@ func authHandler(next http.Handler, publicKey ed25519.PublicKey) http.Handler {
 			// Cached token has since expired (or become otherwise invalid);
 			// drop it so the cache does not accumulate stale entries.
 			cacheLock.Lock()
-			delete(cache, rawtoken)
+			if _, ok := cache[rawtoken]; ok {
+				delete(cache, rawtoken)
+				tokenCacheEntries.Add(-1)
+			}
 			cacheLock.Unlock()
 		}
 
-		// The actual token is ignored for now.
 		// In case expiration and so on are specified, the Parse function
-		// already returns an error for expired tokens.
+		// already returns an error for expired tokens. The roles claim is
+		// checked after the token has been cached.
 		var err error
 		token, err = jwt.Parse(rawtoken, func(t *jwt.Token) (any, error) {
 			if t.Method != jwt.SigningMethodEdDSA {
@@ -58,6 +152,7 @@ func authHandler(next http.Handler, publicKey ed25519.PublicKey) http.Handler {
 			return publicKey, nil
 		})
 		if err != nil {
+			authFailures.inc("unauthorized")
 			http.Error(rw, err.Error(), http.StatusUnauthorized)
 			return
 		}
@@ -72,15 +167,25 @@ func authHandler(next http.Handler, publicKey ed25519.PublicKey) http.Handler {
 			for k, t := range cache {
 				if t.Claims.Valid() != nil {
 					delete(cache, k)
+					tokenCacheEntries.Add(-1)
 				}
 			}
 			if len(cache) >= maxTokenCacheSize {
+				tokenCacheEntries.Add(-int64(len(cache)))
 				clear(cache)
 			}
 		}
+		if _, ok := cache[rawtoken]; !ok {
+			tokenCacheEntries.Add(1)
+		}
 		cache[rawtoken] = token
 		cacheLock.Unlock()
 
+		if !authorize(token, allowedRoles) {
+			denyAccess(rw, r, allowedRoles)
+			return
+		}
+
 		// Let request through...
 		next.ServeHTTP(rw, r)
 	})
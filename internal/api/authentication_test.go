@@ -0,0 +1,136 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// signToken returns a token signed with key carrying claims.
+func signToken(t *testing.T, key ed25519.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// authStatus sends a request with the Authorization header auth through an
+// authHandler for the policy and returns the status code.
+func authStatus(t *testing.T, publicKey ed25519.PublicKey, policy, auth string) int {
+	t.Helper()
+	ok := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})
+	handler := authHandler(ok, publicKey, routeRoles(policy))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/"+policy+"/", nil)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	return rw.Code
+}
+
+func TestAuthHandler(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bearer := func(claims jwt.MapClaims) string {
+		return "Bearer " + signToken(t, privateKey, claims)
+	}
+	admin := bearer(jwt.MapClaims{"sub": "a", "roles": []string{"ROLE_ADMIN"}})
+	api := bearer(jwt.MapClaims{"sub": "b", "roles": []string{"Api"}})
+	user := bearer(jwt.MapClaims{"sub": "c", "roles": []string{"user"}})
+	plain := bearer(jwt.MapClaims{"sub": "d"})
+	invalidRoles := bearer(jwt.MapClaims{"sub": "e", "roles": "admin"})
+	expired := bearer(jwt.MapClaims{"sub": "f", "roles": []string{"admin"}, "exp": time.Now().Add(-time.Hour).Unix()})
+	foreign := "Bearer " + signToken(t, otherKey, jwt.MapClaims{"sub": "g", "roles": []string{"admin"}})
+
+	tests := []struct {
+		name, auth string
+		want       map[string]int // Status by policy, 0 for all others
+		status     int
+	}{
+		{"no header", "", nil, http.StatusUnauthorized},
+		{"no bearer", "Basic YTpi", nil, http.StatusUnauthorized},
+		{"malformed", "Bearer xyz", nil, http.StatusUnauthorized},
+		{"wrong key", foreign, nil, http.StatusUnauthorized},
+		{"expired", expired, nil, http.StatusUnauthorized},
+		{"no roles claim", plain, nil, http.StatusForbidden},
+		{"roles claim no list", invalidRoles, nil, http.StatusForbidden},
+		{"wrong role", user, nil, http.StatusForbidden},
+		{"ROLE_ADMIN", admin, nil, http.StatusOK},
+		{"Api", api, map[string]int{"free": http.StatusForbidden, "debug": http.StatusForbidden, "reload": http.StatusForbidden}, http.StatusOK},
+	}
+
+	policies := []string{"metrics"}
+	for _, rt := range routes {
+		policies = append(policies, rt.policy)
+	}
+	for _, tt := range tests {
+		for _, policy := range policies {
+			want, ok := tt.want[policy]
+			if !ok {
+				want = tt.status
+			}
+			// Twice, the second time from the token cache.
+			for range 2 {
+				if got := authStatus(t, publicKey, policy, tt.auth); got != want {
+					t.Errorf("%s on %s: status %d, want %d", tt.name, policy, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestRouteRolesOverride(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Keys.RouteRoles = map[string][]string{"query": {}, "free": {"ROLE_Support"}}
+	t.Cleanup(func() { config.Keys.RouteRoles = nil })
+
+	plain := "Bearer " + signToken(t, privateKey, jwt.MapClaims{"sub": "a"})
+	support := "Bearer " + signToken(t, privateKey, jwt.MapClaims{"sub": "b", "roles": []string{"support"}})
+	admin := "Bearer " + signToken(t, privateKey, jwt.MapClaims{"sub": "c", "roles": []string{"admin"}})
+
+	tests := []struct {
+		policy, auth string
+		want         int
+	}{
+		{"query", plain, http.StatusOK},
+		{"query", "", http.StatusUnauthorized},
+		{"free", support, http.StatusOK},
+		{"free", admin, http.StatusForbidden},
+		{"debug", admin, http.StatusOK},
+		{"debug", support, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := authStatus(t, publicKey, tt.policy, tt.auth); got != tt.want {
+			t.Errorf("%s with %q: status %d, want %d", tt.policy, tt.auth, got, tt.want)
+		}
+	}
+
+	if roles := routeRoles("free"); len(roles) != 1 || roles[0] != "support" {
+		t.Errorf("routeRoles(free) = %v", roles)
+	}
+}
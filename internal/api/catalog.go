@@ -0,0 +1,247 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// This file implements the read-only catalog endpoints, which list the
+// clusters, hosts and sub-levels held by the memory store and the configured
+// metrics, so that clients can build selectors.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
+)
+
+// topologyTypes are the types of the levels below a host. The name of such a
+// level is the type followed by the id, e.g. `hwthread12`.
+var topologyTypes = []string{
+	string(schema.MetricScopeHWThread),
+	string(schema.MetricScopeCore),
+	string(schema.MetricScopeSocket),
+	string(schema.MetricScopeMemoryDomain),
+	string(schema.MetricScopeAccelerator),
+}
+
+// MetricInfo is the configuration of a metric.
+type MetricInfo struct {
+	// Frequency in seconds
+	Frequency int64 `json:"frequency"`
+	// Aggregation strategy: sum, avg or null
+	Aggregation string `json:"aggregation"`
+	// Added at runtime by the 'register' unknown-metrics policy
+	Registered bool `json:"registered,omitempty"`
+	// Unit, plausible range and allowed scopes, if configured
+	Unit   string   `json:"unit,omitempty"`
+	Min    *float64 `json:"min,omitempty"`
+	Max    *float64 `json:"max,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// TopologyType lists the ids of one type of levels below a host or a
+// type-id.
+type TopologyType struct {
+	Type string   `json:"type"`
+	IDs  []string `json:"ids"`
+	// Levels below the ids (subtypes), merged over all ids of the type
+	Subtypes []TopologyType `json:"subtypes,omitempty"`
+}
+
+// HostTopology describes the levels below a host.
+type HostTopology struct {
+	Cluster string         `json:"cluster"`
+	Host    string         `json:"host"`
+	Types   []TopologyType `json:"types"`
+}
+
+// splitLevelName splits the name of a level below a host into type and id.
+// Levels of unknown types are split before the first digit.
+func splitLevelName(name string) (typ, id string) {
+	for _, t := range topologyTypes {
+		if rest, ok := strings.CutPrefix(name, t); ok {
+			return t, rest
+		}
+	}
+	if i := strings.IndexFunc(name, unicode.IsDigit); i > 0 {
+		return name[:i], name[i:]
+	}
+	return name, ""
+}
+
+// compareIDs sorts numeric ids by value, all others lexically.
+func compareIDs(a, b string) int {
+	na, erra := strconv.Atoi(a)
+	nb, errb := strconv.Atoi(b)
+	if erra == nil && errb == nil {
+		return na - nb
+	}
+	return strings.Compare(a, b)
+}
+
+// topology groups the children of the level selected by sel by type. If
+// withSubtypes is set, the levels below them are added as subtypes.
+func topology(ms *metricstore.MemoryStore, sel []string, withSubtypes bool) []TopologyType {
+	children := ms.ListChildren(sel)
+	ids := map[string][]string{}
+	below := map[string][][]string{}
+	for _, child := range children {
+		typ, id := splitLevelName(child)
+		ids[typ] = append(ids[typ], id)
+		below[typ] = append(below[typ], append(slices.Clone(sel), child))
+	}
+
+	types := make([]TopologyType, 0, len(ids))
+	for typ, tids := range ids {
+		slices.SortFunc(tids, compareIDs)
+		tt := TopologyType{Type: typ, IDs: tids}
+		if withSubtypes {
+			tt.Subtypes = mergeTopology(ms, below[typ])
+		}
+		types = append(types, tt)
+	}
+	slices.SortFunc(types, func(a, b TopologyType) int { return strings.Compare(a.Type, b.Type) })
+	return types
+}
+
+// mergeTopology returns the union of the topology below all selectors.
+func mergeTopology(ms *metricstore.MemoryStore, sels [][]string) []TopologyType {
+	merged := map[string]*TopologyType{}
+	for _, sel := range sels {
+		for _, tt := range topology(ms, sel, false) {
+			m, ok := merged[tt.Type]
+			if !ok {
+				m = &TopologyType{Type: tt.Type}
+				merged[tt.Type] = m
+			}
+			for _, id := range tt.IDs {
+				if !slices.Contains(m.IDs, id) {
+					m.IDs = append(m.IDs, id)
+				}
+			}
+		}
+	}
+
+	res := make([]TopologyType, 0, len(merged))
+	for _, m := range merged {
+		slices.SortFunc(m.IDs, compareIDs)
+		res = append(res, *m)
+	}
+	slices.SortFunc(res, func(a, b TopologyType) int { return strings.Compare(a.Type, b.Type) })
+	return res
+}
+
+func writeCatalogResponse(rw http.ResponseWriter, v any) {
+	rw.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		cclog.Errorf("Failed to encode catalog response: %v", err)
+	}
+}
+
+// handleListClusters godoc
+// @summary List clusters
+// @tags catalog
+// @description Lists the clusters the memory store holds data for.
+// @produce     json
+// @success     200            {array}  string              "Cluster names, sorted"
+// @failure     401            {object} ErrorResponse       "Unauthorized"
+// @failure     403            {object} ErrorResponse       "Forbidden"
+// @security    ApiKeyAuth
+// @router      /clusters/ [get]
+func handleListClusters(rw http.ResponseWriter, r *http.Request) {
+	clusters := metricstore.GetMemoryStore().ListChildren(nil)
+	slices.Sort(clusters)
+	writeCatalogResponse(rw, clusters)
+}
+
+// handleListHosts godoc
+// @summary List hosts of a cluster
+// @tags catalog
+// @description Lists the hosts of a cluster the memory store holds data for.
+// @produce     json
+// @param       cluster        path     string              true "Cluster name"
+// @success     200            {array}  string              "Host names, sorted"
+// @failure     401            {object} ErrorResponse       "Unauthorized"
+// @failure     403            {object} ErrorResponse       "Forbidden"
+// @failure     404            {object} ErrorResponse       "Cluster not found"
+// @security    ApiKeyAuth
+// @router      /clusters/{cluster}/hosts/ [get]
+func handleListHosts(rw http.ResponseWriter, r *http.Request) {
+	cluster := r.PathValue("cluster")
+	hosts := metricstore.GetMemoryStore().ListChildren([]string{cluster})
+	if hosts == nil {
+		handleError(fmt.Errorf("cluster '%s' not found", cluster), http.StatusNotFound, rw)
+		return
+	}
+	slices.Sort(hosts)
+	writeCatalogResponse(rw, hosts)
+}
+
+// handleHostTopology godoc
+// @summary Topology of a host
+// @tags catalog
+// @description Lists the levels below a host (e.g. sockets, cores,
+// hwthreads and accelerators) grouped by type, with the levels below them
+// as subtypes. The types and ids can be used as `type`/`type-ids` and
+// `subtype`/`subtype-ids` of a query.
+// @produce     json
+// @param       cluster        path     string              true "Cluster name"
+// @param       host           path     string              true "Host name"
+// @success     200            {object} HostTopology        "Levels below the host"
+// @failure     401            {object} ErrorResponse       "Unauthorized"
+// @failure     403            {object} ErrorResponse       "Forbidden"
+// @failure     404            {object} ErrorResponse       "Host not found"
+// @security    ApiKeyAuth
+// @router      /clusters/{cluster}/hosts/{host} [get]
+func handleHostTopology(rw http.ResponseWriter, r *http.Request) {
+	cluster, host := r.PathValue("cluster"), r.PathValue("host")
+	ms := metricstore.GetMemoryStore()
+	if ms.ListChildren([]string{cluster, host}) == nil {
+		handleError(fmt.Errorf("host '%s' not found in cluster '%s'", host, cluster), http.StatusNotFound, rw)
+		return
+	}
+
+	writeCatalogResponse(rw, HostTopology{
+		Cluster: cluster,
+		Host:    host,
+		Types:   topology(ms, []string{cluster, host}, true),
+	})
+}
+
+// handleListMetrics godoc
+// @summary List configured metrics
+// @tags catalog
+// @description Lists the metrics of the metrics section (and metrics
+// registered at runtime) with their frequency and aggregation.
+// @produce     json
+// @success     200            {object} map[string]MetricInfo "Metrics by name"
+// @failure     401            {object} ErrorResponse       "Unauthorized"
+// @failure     403            {object} ErrorResponse       "Forbidden"
+// @security    ApiKeyAuth
+// @router      /metrics/ [get]
+func handleListMetrics(rw http.ResponseWriter, r *http.Request) {
+	res := map[string]MetricInfo{}
+	for name, mc := range metricConfigs(metricstore.GetMemoryStore()) {
+		ms, _ := config.GetMetricSchema(name)
+		res[name] = MetricInfo{
+			Frequency:   mc.Frequency,
+			Aggregation: config.AggregationName(mc.Aggregation),
+			Registered:  config.IsRegistered(name),
+			Unit:        ms.Unit,
+			Min:         ms.Min,
+			Max:         ms.Max,
+			Scopes:      ms.Scopes,
+		}
+	}
+	writeCatalogResponse(rw, res)
+}
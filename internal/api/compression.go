@@ -0,0 +1,182 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// errUnsupportedEncoding is returned for request bodies with a
+// Content-Encoding other than gzip or zstd.
+type errUnsupportedEncoding string
+
+func (e errUnsupportedEncoding) Error() string {
+	return fmt.Sprintf("unsupported Content-Encoding %q, use gzip or zstd", string(e))
+}
+
+// encodingErrorStatus returns the status code for an error of
+// decompressBody.
+func encodingErrorStatus(err error) int {
+	var ue errUnsupportedEncoding
+	if errors.As(err, &ue) {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// Encoders and decoders allocate large buffers, they are reused across
+// requests.
+var (
+	gzipReaders  sync.Pool // *gzip.Reader
+	gzipWriters  sync.Pool // *gzip.Writer
+	zstdDecoders sync.Pool // *zstd.Decoder
+	zstdEncoders sync.Pool // *zstd.Encoder
+)
+
+// decompressedBody wraps a request body according to its Content-Encoding.
+// The data is decompressed while it is read, so the body is still streamed.
+type decompressedBody struct {
+	io.Reader
+	release func()
+}
+
+// Close returns the decoder to its pool. The request body itself is closed
+// by the HTTP server.
+func (db *decompressedBody) Close() error {
+	if db.release != nil {
+		db.release()
+		db.release = nil
+	}
+	return nil
+}
+
+// decompressBody returns the body of r, decompressed if the request has a
+// `Content-Encoding: gzip` or `zstd` header. The result has to be closed.
+func decompressBody(r *http.Request) (*decompressedBody, error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return &decompressedBody{Reader: r.Body}, nil
+	case "gzip", "x-gzip":
+		if zr, ok := gzipReaders.Get().(*gzip.Reader); ok {
+			if err := zr.Reset(r.Body); err != nil {
+				return nil, fmt.Errorf("decoding gzip body failed: %w", err)
+			}
+			return &decompressedBody{Reader: zr, release: func() { gzipReaders.Put(zr) }}, nil
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("decoding gzip body failed: %w", err)
+		}
+		return &decompressedBody{Reader: zr, release: func() { gzipReaders.Put(zr) }}, nil
+	case "zstd":
+		zr, ok := zstdDecoders.Get().(*zstd.Decoder)
+		if !ok {
+			var err error
+			if zr, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1)); err != nil {
+				return nil, err
+			}
+		}
+		if err := zr.Reset(r.Body); err != nil {
+			return nil, fmt.Errorf("decoding zstd body failed: %w", err)
+		}
+		return &decompressedBody{Reader: zr, release: func() {
+			zr.Reset(nil)
+			zstdDecoders.Put(zr)
+		}}, nil
+	default:
+		return nil, errUnsupportedEncoding(encoding)
+	}
+}
+
+// acceptedEncoding picks the response encoding from the Accept-Encoding
+// header of r: zstd or gzip, whichever has the higher quality (zstd on a
+// tie), or "" if the client accepts neither.
+func acceptedEncoding(r *http.Request) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(strings.Join(r.Header.Values("Accept-Encoding"), ","), ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		if (name == "zstd" || name == "gzip") && (q > bestQ || q == bestQ && name == "zstd" && q > 0) {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressedResponseWriter compresses everything written to the response.
+type compressedResponseWriter struct {
+	http.ResponseWriter
+	w     io.Writer
+	flush func() error
+}
+
+func (cw *compressedResponseWriter) Write(b []byte) (int, error) {
+	return cw.w.Write(b)
+}
+
+// Flush sends the data compressed so far to the client, so streamed
+// responses keep working.
+func (cw *compressedResponseWriter) Flush() {
+	cw.flush()
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// compressResponse wraps rw so that the response is compressed with the
+// encoding negotiated by acceptedEncoding. The returned function completes
+// the compressed stream and has to be called after the handler is done.
+func compressResponse(rw http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	rw.Header().Add("Vary", "Accept-Encoding")
+	encoding := acceptedEncoding(r)
+	if encoding == "" {
+		return rw, func() {}
+	}
+	rw.Header().Set("Content-Encoding", encoding)
+	rw.Header().Del("Content-Length")
+
+	if encoding == "zstd" {
+		zw, ok := zstdEncoders.Get().(*zstd.Encoder)
+		if !ok {
+			zw, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedFastest))
+		}
+		zw.Reset(rw)
+		return &compressedResponseWriter{ResponseWriter: rw, w: zw, flush: zw.Flush}, func() {
+			zw.Close()
+			zstdEncoders.Put(zw)
+		}
+	}
+
+	zw, ok := gzipWriters.Get().(*gzip.Writer)
+	if !ok {
+		zw, _ = gzip.NewWriterLevel(nil, gzip.BestSpeed)
+	}
+	zw.Reset(rw)
+	return &compressedResponseWriter{ResponseWriter: rw, w: zw, flush: zw.Flush}, func() {
+		zw.Close()
+		gzipWriters.Put(zw)
+	}
+}
@@ -0,0 +1,441 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// This file implements the limited variant of `/api/debug`. The internals of
+// the memory store levels are not exported, so the output of
+// MemoryStore.DebugDump is parsed and only the parts selected by the request
+// are returned. DebugDump holds the read locks of a level and all levels
+// below it while it serializes them, so it is only called for the selected
+// host levels (or levels below) one at a time; the levels above are walked
+// with MemoryStore.ListChildren.
+
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
+)
+
+// debugHostDepth is the depth of the host levels in the tree. Lines are
+// always written to a host or a level below it, so the root and cluster
+// levels hold no buffers of their own.
+const debugHostDepth = 2
+
+// debugParams lists the query parameters that switch /api/debug from the
+// full dump to the limited output.
+var debugParams = []string{"depth", "limit", "offset", "metric", "from", "to", "summary"}
+
+// debugOptions restricts which part of the tree is returned by /api/debug.
+type debugOptions struct {
+	selector []string
+	depth    int             // Levels below the selected one, -1 for all
+	limit    int             // Children per level, 0 for all
+	offset   int             // Children of the selected level to skip
+	metrics  map[string]bool // nil for all metrics
+	from, to int64           // Only buffers overlapping [from, to], 0 for open
+	summary  bool
+}
+
+// debugBuffer is one buffer of the chain of a metric as reported by
+// MemoryStore.DebugDump.
+type debugBuffer struct {
+	Start int64 `json:"start"`
+	Len   int64 `json:"len"`
+	End   int64 `json:"end"`
+	Saved bool  `json:"saved,omitempty"`
+}
+
+// debugSummary describes the buffers of a metric without listing them.
+type debugSummary struct {
+	Buffers int   `json:"buffers"`
+	Samples int64 `json:"samples"`
+	First   int64 `json:"first"`
+	Last    int64 `json:"last"`
+	Bytes   int64 `json:"bytes"`
+}
+
+type debugLevel struct {
+	Metrics       map[string][]debugBuffer `json:"metrics,omitempty"`
+	Summary       map[string]*debugSummary `json:"summary,omitempty"`
+	Bytes         int64                    `json:"bytes,omitempty"`
+	Children      map[string]*debugLevel   `json:"children,omitempty"`
+	TotalChildren int                      `json:"total-children"`
+}
+
+type debugResponse struct {
+	Selector   []string    `json:"selector"`
+	Data       *debugLevel `json:"data"`
+	NextOffset int         `json:"next-offset,omitempty"`
+}
+
+// parseDebugOptions reads the limits from the query string. The second
+// return value is false if none of them is set, in which case the full dump
+// is returned as before.
+func parseDebugOptions(r *http.Request) (*debugOptions, bool, error) {
+	q := r.URL.Query()
+	if !slices.ContainsFunc(debugParams, q.Has) {
+		return nil, false, nil
+	}
+
+	opts := &debugOptions{depth: -1}
+	if raw := q.Get("selector"); raw != "" {
+		opts.selector = strings.Split(raw, ":")
+	}
+
+	ints := []struct {
+		key string
+		dst *int
+	}{{"depth", &opts.depth}, {"limit", &opts.limit}, {"offset", &opts.offset}}
+	for _, p := range ints {
+		if raw := q.Get(p.key); raw != "" {
+			v, err := strconv.Atoi(raw)
+			if err != nil || v < 0 {
+				return nil, true, fmt.Errorf("invalid value for '%s': %#v", p.key, raw)
+			}
+			*p.dst = v
+		}
+	}
+
+	times := []struct {
+		key string
+		dst *int64
+	}{{"from", &opts.from}, {"to", &opts.to}}
+	for _, p := range times {
+		if raw := q.Get(p.key); raw != "" {
+			v, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return nil, true, fmt.Errorf("invalid value for '%s': %#v", p.key, raw)
+			}
+			*p.dst = v
+		}
+	}
+
+	if raw := q.Get("metric"); raw != "" {
+		opts.metrics = map[string]bool{}
+		for _, m := range strings.Split(raw, ",") {
+			opts.metrics[m] = true
+		}
+	}
+
+	if raw := q.Get("summary"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid value for 'summary': %#v", raw)
+		}
+		opts.summary = v
+	}
+
+	return opts, true, nil
+}
+
+// page returns the children of the level at path that fall into the
+// requested page, in lexical order, the total number of children and
+// whether more children follow the page.
+func (o *debugOptions) page(ms *metricstore.MemoryStore, path []string) ([]string, int, bool) {
+	children := ms.ListChildren(append(slices.Clone(o.selector), path...))
+	slices.Sort(children)
+	total := len(children)
+
+	if len(path) == 0 {
+		children = children[min(o.offset, len(children)):]
+	}
+	if o.limit > 0 && len(children) > o.limit {
+		return children[:o.limit], total, true
+	}
+	return children, total, false
+}
+
+// included collects the paths of the levels below path that are part of
+// the output. It is only called for host levels or levels below them, whose
+// subtrees are small.
+func (o *debugOptions) included(ms *metricstore.MemoryStore, path []string, res map[string]bool) (more bool) {
+	if o.depth >= 0 && len(path) >= o.depth {
+		return false
+	}
+
+	children, _, more := o.page(ms, path)
+	for _, c := range children {
+		p := append(slices.Clone(path), c)
+		res[strings.Join(p, ":")] = true
+		o.included(ms, p, res)
+	}
+	return more
+}
+
+// debugParser builds a debugLevel tree from the output of DebugDump.
+type debugParser struct {
+	dec      *json.Decoder
+	opts     *debugOptions
+	included map[string]bool
+}
+
+func (p *debugParser) expect(delim json.Delim) error {
+	t, err := p.dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := t.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("unexpected token %v in debug dump", t)
+	}
+	return nil
+}
+
+// level parses the object of the level at path. The data of the level is
+// added to target, or dropped if target is nil. In summary mode, levels
+// below the maximum depth are folded into their ancestor.
+func (p *debugParser) level(path []string, target *debugLevel, folded bool) error {
+	for p.dec.More() {
+		t, err := p.dec.Token()
+		if err != nil {
+			return err
+		}
+		name, ok := t.(string)
+		if !ok {
+			return fmt.Errorf("unexpected token %v in debug dump", t)
+		}
+
+		t, err = p.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case json.Delim('['):
+			if err := p.metric(name, target); err != nil {
+				return err
+			}
+		case json.Delim('{'):
+			childPath := append(slices.Clone(path), name)
+			child, childFolded := (*debugLevel)(nil), folded
+			if target != nil {
+				if !folded {
+					target.TotalChildren++
+				}
+				switch {
+				case p.included[strings.Join(childPath, ":")]:
+					child = &debugLevel{}
+					if target.Children == nil {
+						target.Children = map[string]*debugLevel{}
+					}
+					target.Children[name] = child
+				case p.opts.summary && p.opts.depth >= 0 && len(childPath) > p.opts.depth:
+					child, childFolded = target, true
+				}
+			}
+			if err := p.level(childPath, child, childFolded); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected token %v in debug dump", t)
+		}
+	}
+	return p.expect('}')
+}
+
+func (p *debugParser) metric(slot string, target *debugLevel) error {
+	// Registered metrics are shown by their name, unused slots not at all.
+	name := config.MetricName(slot)
+	keep := target != nil && !config.IsReserved(name) && (p.opts.metrics == nil || p.opts.metrics[name])
+	for p.dec.More() {
+		b := debugBuffer{}
+		if err := p.dec.Decode(&b); err != nil {
+			return err
+		}
+		if !keep || (p.opts.from != 0 && b.End <= p.opts.from) || (p.opts.to != 0 && b.Start > p.opts.to) {
+			continue
+		}
+
+		if !p.opts.summary {
+			if target.Metrics == nil {
+				target.Metrics = map[string][]debugBuffer{}
+			}
+			target.Metrics[name] = append(target.Metrics[name], b)
+			continue
+		}
+
+		if target.Summary == nil {
+			target.Summary = map[string]*debugSummary{}
+		}
+		s, ok := target.Summary[name]
+		if !ok {
+			s = &debugSummary{First: b.Start, Last: b.End}
+			target.Summary[name] = s
+		}
+		s.Buffers++
+		s.Samples += b.Len
+		s.First = min(s.First, b.Start)
+		s.Last = max(s.Last, b.End)
+		s.Bytes += b.Len * 8
+		target.Bytes += b.Len * 8
+	}
+	return p.expect(']')
+}
+
+// trailingCommaReader drops the commas before closing brackets that
+// DebugDump leaves behind in levels without children, so the dump can be
+// parsed by encoding/json.
+type trailingCommaReader struct {
+	r        *bufio.Reader
+	comma    bool // A comma was read but not yet passed on
+	next     byte // Byte to pass on after the comma, 0 for none
+	inString bool
+	escaped  bool
+}
+
+func (t *trailingCommaReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if t.next != 0 {
+			p[n], t.next = t.next, 0
+			n++
+			continue
+		}
+
+		c, err := t.r.ReadByte()
+		if err != nil {
+			if t.comma {
+				p[n], t.comma = ',', false
+				n++
+			}
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+
+		switch {
+		case t.inString:
+			t.inString = t.escaped || c != '"'
+			t.escaped = !t.escaped && c == '\\'
+		case t.comma && (c == ' ' || c == '\t' || c == '\n' || c == '\r'):
+			continue
+		case t.comma && (c == '}' || c == ']'):
+			t.comma = false
+		case c == ',':
+			t.comma = true
+			continue
+		case c == '"':
+			t.inString = true
+		}
+
+		if t.comma {
+			p[n], t.comma, t.next = ',', false, c
+		} else {
+			p[n] = c
+		}
+		n++
+	}
+	return n, nil
+}
+
+// limitedDebugDump returns the part of the tree selected by opts.
+func limitedDebugDump(ms *metricstore.MemoryStore, opts *debugOptions) (*debugResponse, error) {
+	// ListChildren returns nil only for levels that do not exist.
+	if ms.ListChildren(opts.selector) == nil {
+		return nil, fmt.Errorf("not found: %#v", opts.selector)
+	}
+
+	res := &debugResponse{Selector: opts.selector, Data: &debugLevel{}}
+	if res.Selector == nil {
+		res.Selector = []string{}
+	}
+
+	more, err := walkDebugLevel(ms, opts, nil, res.Data, false)
+	if err != nil {
+		return nil, err
+	}
+	if more {
+		res.NextOffset = opts.offset + opts.limit
+	}
+	return res, nil
+}
+
+// walkDebugLevel adds the level at path to target. Above the host levels,
+// only the children in the requested page are visited; host levels and the
+// levels below them are dumped. If folded is set, the summaries of all
+// levels below are added to target itself.
+func walkDebugLevel(ms *metricstore.MemoryStore, opts *debugOptions, path []string, target *debugLevel, folded bool) (bool, error) {
+	if len(opts.selector)+len(path) >= debugHostDepth {
+		return dumpDebugLevel(ms, opts, path, target, folded)
+	}
+
+	if folded {
+		for _, name := range ms.ListChildren(append(slices.Clone(opts.selector), path...)) {
+			if _, err := walkDebugLevel(ms, opts, append(slices.Clone(path), name), target, true); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+
+	children, total, more := opts.page(ms, path)
+	target.TotalChildren = total
+	for _, name := range children {
+		childPath := append(slices.Clone(path), name)
+		if opts.depth >= 0 && len(childPath) > opts.depth {
+			if opts.summary {
+				if _, err := walkDebugLevel(ms, opts, childPath, target, true); err != nil {
+					return false, err
+				}
+			}
+			continue
+		}
+
+		child := &debugLevel{}
+		if _, err := walkDebugLevel(ms, opts, childPath, child, false); err != nil {
+			return false, err
+		}
+		if target.Children == nil {
+			target.Children = map[string]*debugLevel{}
+		}
+		target.Children[name] = child
+	}
+	return more, nil
+}
+
+// dumpDebugLevel adds the level at path, a host level or a level below it,
+// to target using a single call of DebugDump. The dump is written to memory
+// first, so the level locks are not held longer than the serialization
+// takes.
+func dumpDebugLevel(ms *metricstore.MemoryStore, opts *debugOptions, path []string, target *debugLevel, folded bool) (bool, error) {
+	included := map[string]bool{}
+	more := false
+	if !folded {
+		more = opts.included(ms, path, included)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ms.DebugDump(bufio.NewWriter(buf), append(slices.Clone(opts.selector), path...)); err != nil {
+		if len(path) > 0 {
+			// The level was freed after its parent was listed.
+			return false, nil
+		}
+		return false, err
+	}
+
+	p := &debugParser{dec: json.NewDecoder(&trailingCommaReader{r: bufio.NewReader(buf)}), opts: opts, included: included}
+	// The dump has the form {"data":{...}}.
+	if err := p.expect('{'); err != nil {
+		return false, err
+	}
+	if _, err := p.dec.Token(); err != nil {
+		return false, err
+	}
+	if err := p.expect('{'); err != nil {
+		return false, err
+	}
+	if err := p.level(path, target, folded); err != nil {
+		return false, err
+	}
+	return more, p.expect('}')
+}
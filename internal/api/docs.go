@@ -23,6 +23,160 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/clusters/": {
+            "get": {
+                "description": "Lists the clusters the memory store holds data for.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catalog"
+                ],
+                "summary": "List clusters",
+                "responses": {
+                    "200": {
+                        "description": "Cluster names, sorted",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ]
+            }
+        },
+        "/clusters/{cluster}/hosts/": {
+            "get": {
+                "description": "Lists the hosts of a cluster the memory store holds data for.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catalog"
+                ],
+                "summary": "List hosts of a cluster",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cluster name",
+                        "name": "cluster",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Host names, sorted",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Cluster not found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ]
+            }
+        },
+        "/clusters/{cluster}/hosts/{host}": {
+            "get": {
+                "description": "Lists the levels below a host (e.g. sockets, cores,",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catalog"
+                ],
+                "summary": "Topology of a host",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cluster name",
+                        "name": "cluster",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Host name",
+                        "name": "host",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Levels below the host",
+                        "schema": {
+                            "$ref": "#/definitions/api.HostTopology"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Host not found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ]
+            }
+        },
         "/debug/": {
             "post": {
                 "description": "This endpoint allows the users to print the content of",
@@ -39,6 +193,48 @@ const docTemplate = `{
                         "description": "Selector",
                         "name": "selector",
                         "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of levels below the selected one",
+                        "name": "depth",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of children per level",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of children of the selected level to skip",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated list of metrics",
+                        "name": "metric",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only buffers with data after this timestamp",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only buffers with data before this timestamp",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Report buffer counts, time range and memory instead of buffers",
+                        "name": "summary",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -136,37 +332,20 @@ const docTemplate = `{
                 ]
             }
         },
-        "/healthcheck/": {
+        "/grafana/": {
             "get": {
-                "description": "This endpoint allows the users to check if a node is healthy",
-                "produces": [
-                    "application/json"
-                ],
+                "description": "Answers the connection test of the Grafana JSON datasource.",
                 "tags": [
-                    "healthcheck"
-                ],
-                "summary": "HealthCheck endpoint",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Selector",
-                        "name": "selector",
-                        "in": "query"
-                    }
+                    "grafana"
                 ],
+                "summary": "Grafana datasource connection test",
                 "responses": {
                     "200": {
-                        "description": "Debug dump",
+                        "description": "ok",
                         "schema": {
                             "type": "string"
                         }
                     },
-                    "400": {
-                        "description": "Bad Request",
-                        "schema": {
-                            "$ref": "#/definitions/api.ErrorResponse"
-                        }
-                    },
                     "401": {
                         "description": "Unauthorized",
                         "schema": {
@@ -178,9 +357,46 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
+                    }
+                },
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ]
+            }
+        },
+        "/grafana/annotations": {
+            "post": {
+                "description": "The metric store has no events, so no annotations are",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "grafana"
+                ],
+                "summary": "Grafana datasource annotations",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
@@ -193,9 +409,9 @@ const docTemplate = `{
                 ]
             }
         },
-        "/query/": {
-            "get": {
-                "description": "This endpoint allows the users to retrieve data from the",
+        "/grafana/query": {
+            "post": {
+                "description": "Returns one time series per host (and type id, if not",
                 "consumes": [
                     "application/json"
                 ],
@@ -203,25 +419,34 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "query"
+                    "grafana"
                 ],
-                "summary": "Query metrics",
+                "summary": "Grafana datasource query",
                 "parameters": [
                     {
-                        "description": "API query payload object",
+                        "description": "Grafana query",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/api.APIQueryRequest"
+                            "$ref": "#/definitions/api.GrafanaQueryRequest"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "API query response object",
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.APIQueryResponse"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.GrafanaSeries"
+                            }
+                        },
+                        "headers": {
+                            "X-Query-Cost": {
+                                "type": "string",
+                                "description": "Selectors, data points and time range of the request"
+                            }
                         }
                     },
                     "400": {
@@ -242,6 +467,18 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
+                    "413": {
+                        "description": "Request body too large",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Query exceeds the configured limits",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -256,27 +493,37 @@ const docTemplate = `{
                 ]
             }
         },
-        "/write/": {
+        "/grafana/search": {
             "post": {
+                "description": "Returns the names of all configured metrics that contain the",
                 "consumes": [
-                    "text/plain"
+                    "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
+                "tags": [
+                    "grafana"
+                ],
+                "summary": "Grafana datasource metric search",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "If the lines in the body do not have a cluster tag, use this value instead.",
-                        "name": "cluster",
-                        "in": "query"
+                        "description": "Search string",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/api.GrafanaSearchRequest"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "ok",
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
                         }
                     },
                     "400": {
@@ -296,12 +543,6 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
-                    },
-                    "500": {
-                        "description": "Internal Server Error",
-                        "schema": {
-                            "$ref": "#/definitions/api.ErrorResponse"
-                        }
                     }
                 },
                 "security": [
@@ -310,38 +551,684 @@ const docTemplate = `{
                     }
                 ]
             }
-        }
-    },
-    "definitions": {
-        "api.APIMetricData": {
-            "type": "object",
-            "properties": {
-                "avg": {
-                    "type": "number"
-                },
-                "data": {
-                    "type": "array",
-                    "items": {
-                        "type": "number"
-                    }
-                },
-                "error": {
-                    "type": "string"
-                },
-                "from": {
-                    "type": "integer"
-                },
-                "max": {
-                    "type": "number"
+        },
+        "/healthcheck/": {
+            "get": {
+                "description": "This endpoint allows the users to check if a node is healthy",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "healthcheck"
+                ],
+                "summary": "HealthCheck endpoint",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Selector",
+                        "name": "selector",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Debug dump",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ]
+            }
+        },
+        "/metrics/": {
+            "get": {
+                "description": "Lists the metrics of the metrics section (and metrics",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catalog"
+                ],
+                "summary": "List configured metrics",
+                "responses": {
+                    "200": {
+                        "description": "Metrics by name",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "$ref": "#/definitions/api.MetricInfo"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ]
+            }
+        },
+        "/prom/write/": {
+            "post": {
+                "description": "Write data to the in-memory store using the Prometheus",
+                "consumes": [
+                    "application/x-protobuf"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "write"
+                ],
+                "summary": "Receive metrics via Prometheus remote-write",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "If the series do not have a cluster label, use this value instead.",
+                        "name": "cluster",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ]
+            }
+        },
+        "/query/": {
+            "get": {
+                "description": "This endpoint allows the users to retrieve data from the",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/csv",
+                    "application/vnd.apache.arrow.stream"
+                ],
+                "tags": [
+                    "query"
+                ],
+                "summary": "Query metrics",
+                "parameters": [
+                    {
+                        "description": "API query payload object",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIQueryRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cluster (URL form)",
+                        "name": "cluster",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "csv",
+                        "description": "Hosts, repeated or comma-separated (URL form)",
+                        "name": "host",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "csv",
+                        "description": "Metrics, repeated or comma-separated (URL form, replaces the body)",
+                        "name": "metric",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Start of the time range (URL form)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "End of the time range (URL form)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Stream the results of each query as soon as they are read",
+                        "name": "stream",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Compress the response with zstd or gzip",
+                        "name": "Accept-Encoding",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "API query response object",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIQueryResponse"
+                        },
+                        "headers": {
+                            "X-Query-Cost": {
+                                "type": "string",
+                                "description": "Selectors, data points and time range of the request"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request, with the invalid fields",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request body too large",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Query exceeds the configured limits",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ]
+            },
+            "post": {
+                "description": "This endpoint allows the users to retrieve data from the",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/csv",
+                    "application/vnd.apache.arrow.stream"
+                ],
+                "tags": [
+                    "query"
+                ],
+                "summary": "Query metrics",
+                "parameters": [
+                    {
+                        "description": "API query payload object",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIQueryRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cluster (URL form)",
+                        "name": "cluster",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "csv",
+                        "description": "Hosts, repeated or comma-separated (URL form)",
+                        "name": "host",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "csv",
+                        "description": "Metrics, repeated or comma-separated (URL form, replaces the body)",
+                        "name": "metric",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Start of the time range (URL form)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "End of the time range (URL form)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Stream the results of each query as soon as they are read",
+                        "name": "stream",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Compress the response with zstd or gzip",
+                        "name": "Accept-Encoding",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "API query response object",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIQueryResponse"
+                        },
+                        "headers": {
+                            "X-Query-Cost": {
+                                "type": "string",
+                                "description": "Selectors, data points and time range of the request"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request, with the invalid fields",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request body too large",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Query exceeds the configured limits",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ]
+            }
+        },
+        "/query/schema": {
+            "get": {
+                "description": "Returns the JSON schema the body of ` + "`" + `/api/query/` + "`" + ` is",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "query"
+                ],
+                "summary": "JSON schema of the query request",
+                "responses": {
+                    "200": {
+                        "description": "JSON schema",
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ]
+            }
+        },
+        "/reload/": {
+            "post": {
+                "description": "This endpoint re-reads the ` + "`" + `metrics` + "`" + ` section of the config",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reload"
+                ],
+                "summary": "Reload the metric configuration",
+                "responses": {
+                    "200": {
+                        "description": "Applied and pending changes",
+                        "schema": {
+                            "$ref": "#/definitions/api.ReloadResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ]
+            }
+        },
+        "/unknown-metrics/": {
+            "get": {
+                "description": "Lists the metrics missing in the metrics section that were",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "write"
+                ],
+                "summary": "Unknown metrics",
+                "responses": {
+                    "200": {
+                        "description": "Unknown metrics, sorted by name",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.UnknownMetric"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ]
+            }
+        },
+        "/write/": {
+            "post": {
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "If the lines in the body do not have a cluster tag, use this value instead.",
+                        "name": "cluster",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Skip invalid lines instead of aborting and return a summary of the write",
+                        "name": "report",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Compression of the body: gzip or zstd",
+                        "name": "Content-Encoding",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Summary of the write if report=true",
+                        "schema": {
+                            "$ref": "#/definitions/api.WriteReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Content-Encoding",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ]
+            }
+        }
+    },
+    "definitions": {
+        "api.APIMetricData": {
+            "type": "object",
+            "properties": {
+                "avg": {
+                    "type": "number"
+                },
+                "count": {
+                    "type": "integer"
+                },
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "type": "number"
+                    }
+                },
+                "downsample": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "from": {
+                    "type": "integer"
+                },
+                "max": {
+                    "type": "number"
+                },
+                "median": {
+                    "description": "The following statistics are only set if requested in ` + "`" + `stats` + "`" + `.",
+                    "type": "number"
                 },
                 "min": {
                     "type": "number"
                 },
+                "nan-count": {
+                    "type": "integer"
+                },
+                "percentiles": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "number"
+                    }
+                },
                 "resolution": {
                     "type": "integer"
                 },
+                "stddev": {
+                    "type": "number"
+                },
                 "to": {
                     "type": "integer"
+                },
+                "unit": {
+                    "type": "string"
                 }
             }
         },
@@ -351,12 +1238,27 @@ const docTemplate = `{
                 "aggreg": {
                     "type": "boolean"
                 },
+                "downsample": {
+                    "description": "Function used to reduce the data to the requested resolution: \"avg\",\n\"min\", \"max\", \"last\", \"sum\" or \"lttb\" (default)",
+                    "type": "string"
+                },
                 "host": {
                     "type": "string"
                 },
+                "hosts": {
+                    "description": "Further hosts, read in addition to ` + "`" + `host` + "`" + `",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "metric": {
                     "type": "string"
                 },
+                "operator": {
+                    "description": "Combine all series of the query (all hosts and type ids) into a\nsingle one: \"sum\", \"avg\", \"min\", \"max\" or \"stddev\"",
+                    "type": "string"
+                },
                 "resolution": {
                     "type": "integer"
                 },
@@ -372,6 +1274,10 @@ const docTemplate = `{
                         "type": "string"
                     }
                 },
+                "target-unit": {
+                    "description": "Unit the values are converted to, e.g. \"GB/s\". Requires a unit of the\nmetric in the metrics section. The conversion is applied before\nscale-by.",
+                    "type": "string"
+                },
                 "type": {
                     "type": "string"
                 },
@@ -395,6 +1301,10 @@ const docTemplate = `{
                         "type": "string"
                     }
                 },
+                "for-all-nodes-operator": {
+                    "description": "If set, the metrics in ` + "`" + `for-all-nodes` + "`" + ` are combined across all nodes\ninto a single series using this operator (see APIQuery.Operator).",
+                    "type": "string"
+                },
                 "from": {
                     "type": "integer"
                 },
@@ -404,6 +1314,13 @@ const docTemplate = `{
                         "$ref": "#/definitions/api.APIQuery"
                     }
                 },
+                "stats": {
+                    "description": "Additional statistics per series: \"median\", \"stddev\", \"count\",\n\"nan-count\" and percentiles as \"p\u003cN\u003e\", e.g. \"p10\", \"p90\", \"p99\".",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "to": {
                     "type": "integer"
                 },
@@ -445,11 +1362,336 @@ const docTemplate = `{
                     "description": "Error Message",
                     "type": "string"
                 },
+                "fields": {
+                    "description": "Invalid fields of the request, if any",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.FieldError"
+                    }
+                },
                 "status": {
                     "description": "Statustext of Errorcode",
                     "type": "string"
                 }
             }
+        },
+        "api.FieldError": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "description": "Path of the field, e.g. \"queries[0].type-ids\"",
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.GrafanaQueryRequest": {
+            "type": "object",
+            "properties": {
+                "intervalMs": {
+                    "type": "integer"
+                },
+                "maxDataPoints": {
+                    "type": "integer"
+                },
+                "range": {
+                    "$ref": "#/definitions/api.GrafanaRange"
+                },
+                "targets": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.GrafanaTarget"
+                    }
+                }
+            }
+        },
+        "api.GrafanaRange": {
+            "type": "object",
+            "properties": {
+                "from": {
+                    "type": "string"
+                },
+                "to": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.GrafanaSearchRequest": {
+            "type": "object",
+            "properties": {
+                "target": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.GrafanaSeries": {
+            "type": "object",
+            "properties": {
+                "datapoints": {
+                    "type": "array",
+                    "items": {
+                        "type": "array",
+                        "items": {
+                            "type": "number"
+                        }
+                    }
+                },
+                "refId": {
+                    "type": "string"
+                },
+                "target": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.GrafanaTarget": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "$ref": "#/definitions/api.GrafanaTargetPayload"
+                },
+                "hide": {
+                    "type": "boolean"
+                },
+                "payload": {
+                    "$ref": "#/definitions/api.GrafanaTargetPayload"
+                },
+                "refId": {
+                    "type": "string"
+                },
+                "target": {
+                    "description": "Metric name",
+                    "type": "string"
+                }
+            }
+        },
+        "api.GrafanaTargetPayload": {
+            "type": "object",
+            "properties": {
+                "aggreg": {
+                    "type": "boolean"
+                },
+                "cluster": {
+                    "type": "string"
+                },
+                "downsample": {
+                    "description": "Downsample function, see APIQuery",
+                    "type": "string"
+                },
+                "host": {
+                    "description": "Single host, or list of hosts. If both are empty, all hosts of the\ncluster are queried.",
+                    "type": "string"
+                },
+                "hosts": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "scale-by": {
+                    "type": "number"
+                },
+                "subtype": {
+                    "type": "string"
+                },
+                "subtype-ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "target-unit": {
+                    "description": "Unit the values are converted to, see APIQuery",
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                },
+                "type-ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "api.HostTopology": {
+            "type": "object",
+            "properties": {
+                "cluster": {
+                    "type": "string"
+                },
+                "host": {
+                    "type": "string"
+                },
+                "types": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.TopologyType"
+                    }
+                }
+            }
+        },
+        "api.MetricInfo": {
+            "type": "object",
+            "properties": {
+                "aggregation": {
+                    "description": "Aggregation strategy: sum, avg or null",
+                    "type": "string"
+                },
+                "frequency": {
+                    "description": "Frequency in seconds",
+                    "type": "integer"
+                },
+                "max": {
+                    "type": "number"
+                },
+                "min": {
+                    "type": "number"
+                },
+                "registered": {
+                    "description": "Added at runtime by the 'register' unknown-metrics policy",
+                    "type": "boolean"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "unit": {
+                    "description": "Unit, plausible range and allowed scopes, if configured",
+                    "type": "string"
+                }
+            }
+        },
+        "api.RejectedLine": {
+            "type": "object",
+            "properties": {
+                "line": {
+                    "description": "Line number, starting at 1",
+                    "type": "integer"
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.ReloadResponse": {
+            "type": "object",
+            "properties": {
+                "applied": {
+                    "description": "Changes applied to the running memory store",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "restart-required": {
+                    "description": "Changes that only take effect after a restart",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "api.TopologyType": {
+            "type": "object",
+            "properties": {
+                "ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "subtypes": {
+                    "description": "Levels below the ids (subtypes), merged over all ids of the type",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.TopologyType"
+                    }
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.UnknownMetric": {
+            "type": "object",
+            "properties": {
+                "first-seen": {
+                    "type": "integer"
+                },
+                "hosts": {
+                    "description": "The hosts sending the metric, at most 100",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.UnknownMetricHost"
+                    }
+                },
+                "last-seen": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "registered": {
+                    "description": "The metric was added by the 'register' policy and is stored since",
+                    "type": "boolean"
+                },
+                "samples": {
+                    "description": "Number of samples received",
+                    "type": "integer"
+                }
+            }
+        },
+        "api.UnknownMetricHost": {
+            "type": "object",
+            "properties": {
+                "cluster": {
+                    "type": "string"
+                },
+                "host": {
+                    "type": "string"
+                },
+                "samples": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.WriteReport": {
+            "type": "object",
+            "properties": {
+                "accepted": {
+                    "description": "Lines written to the memory store",
+                    "type": "integer"
+                },
+                "out-of-order": {
+                    "description": "Number of samples older than an earlier sample of the same series in\nthis request. They are stored, but overwrite data in the past.",
+                    "type": "integer"
+                },
+                "rejected": {
+                    "description": "The first rejected lines",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.RejectedLine"
+                    }
+                },
+                "rejected-count": {
+                    "description": "Number of lines that could not be decoded or were invalid",
+                    "type": "integer"
+                },
+                "unknown-metrics": {
+                    "description": "Number of lines per metric that is not configured. These lines are\nignored by the memory store.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                }
+            }
         }
     },
     "securityDefinitions": {
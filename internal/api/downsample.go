@@ -0,0 +1,80 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+)
+
+// downsamplers reduce the values of one time bucket to a single value. They
+// are only called with the non-NaN values of a bucket, values is never
+// empty. "lttb" is not listed, it is done by MemoryStore.Read.
+var downsamplers = map[string]func(values []float64) float64{
+	"avg": seriesOperators["avg"],
+	"min": seriesOperators["min"],
+	"max": seriesOperators["max"],
+	"sum": seriesOperators["sum"],
+	"last": func(values []float64) float64 {
+		return values[len(values)-1]
+	},
+}
+
+func checkDownsample(name string) error {
+	if _, ok := downsamplers[name]; name != "" && name != "lttb" && !ok {
+		return fmt.Errorf("unknown downsample function '%s'", name)
+	}
+	return nil
+}
+
+// downsample reduces data, read at the metric frequency, to the given
+// resolution. The buckets are aligned to multiples of the resolution, so
+// that series downsampled separately share their timestamps.
+func (data *APIMetricData) downsample(fn func([]float64) float64, resolution int64) {
+	if data.Resolution <= 0 || resolution <= data.Resolution {
+		return
+	}
+	if resolution%data.Resolution != 0 {
+		msg := fmt.Sprintf("resolution (%d) must be a multiple of the metric frequency (%d)", resolution, data.Resolution)
+		data.Error = &msg
+		data.Data = nil
+		return
+	}
+
+	from, n := data.From-data.From%resolution, 0
+	if len(data.Data) > 0 {
+		last := data.From + int64(len(data.Data)-1)*data.Resolution
+		n = int((last-from)/resolution) + 1
+	}
+
+	res := make(schema.FloatArray, n)
+	values := make([]float64, 0, resolution/data.Resolution)
+	bucket := 0
+	emit := func() {
+		if len(values) == 0 {
+			res[bucket] = schema.NaN
+		} else {
+			res[bucket] = schema.Float(fn(values))
+		}
+		values = values[:0]
+		bucket++
+	}
+
+	for i, x := range data.Data {
+		for b := int((data.From + int64(i)*data.Resolution - from) / resolution); bucket < b; {
+			emit()
+		}
+		if !x.IsNaN() {
+			values = append(values, float64(x))
+		}
+	}
+	for bucket < n {
+		emit()
+	}
+
+	data.Data, data.From, data.Resolution = res, from, resolution
+}
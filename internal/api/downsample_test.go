@@ -0,0 +1,96 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"testing"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+)
+
+func TestDownsampleAlignment(t *testing.T) {
+	nan := schema.NaN
+	// Samples at 1030, 1090, ..., 1570 downsampled to 300 seconds. The
+	// buckets start at multiples of the resolution: 900, 1200 and 1500.
+	data := &APIMetricData{From: 1030, To: 1630, Resolution: 60, Data: schema.FloatArray{1, 2, 3, nan, 5, 6, 7, 8, 9, 10}}
+	data.downsample(downsamplers["max"], 300)
+
+	if data.From != 900 || data.Resolution != 300 {
+		t.Errorf("from %d, resolution %d, want 900, 300", data.From, data.Resolution)
+	}
+	want := schema.FloatArray{3, 8, 10}
+	if len(data.Data) != len(want) {
+		t.Fatalf("data %v, want %v", data.Data, want)
+	}
+	for i := range want {
+		if data.Data[i] != want[i] {
+			t.Errorf("data[%d] = %v, want %v", i, data.Data[i], want[i])
+		}
+	}
+
+	// A series starting later shares the timestamps of the first one.
+	other := &APIMetricData{From: 1270, To: 1390, Resolution: 60, Data: schema.FloatArray{4, 4}}
+	other.downsample(downsamplers["avg"], 300)
+	if other.From != 1200 || len(other.Data) != 1 || other.Data[0] != 4 {
+		t.Errorf("second series: from %d, data %v, want 1200, [4]", other.From, other.Data)
+	}
+}
+
+func TestDownsampleEmptyBuckets(t *testing.T) {
+	nan := schema.NaN
+	data := &APIMetricData{From: 0, Resolution: 10, Data: schema.FloatArray{1, nan, nan, nan, 2}}
+	data.downsample(downsamplers["last"], 20)
+
+	want := []float64{1, -1, 2} // -1 marks NaN
+	if len(data.Data) != len(want) {
+		t.Fatalf("data %v, want 3 values", data.Data)
+	}
+	for i, w := range want {
+		if got := data.Data[i]; (w == -1) != got.IsNaN() || (w != -1 && float64(got) != w) {
+			t.Errorf("data[%d] = %v, want %v", i, got, w)
+		}
+	}
+}
+
+func TestDownsampleInvalidResolution(t *testing.T) {
+	data := &APIMetricData{From: 0, Resolution: 60, Data: schema.FloatArray{1, 2, 3}}
+	data.downsample(downsamplers["avg"], 90)
+	if data.Error == nil || data.Data != nil {
+		t.Errorf("resolution 90 for frequency 60: error %v, data %v", data.Error, data.Data)
+	}
+
+	// A resolution finer than the frequency leaves the data unchanged.
+	data = &APIMetricData{From: 0, Resolution: 60, Data: schema.FloatArray{1, 2, 3}}
+	data.downsample(downsamplers["avg"], 30)
+	if data.Error != nil || len(data.Data) != 3 || data.Resolution != 60 {
+		t.Errorf("resolution 30 for frequency 60 changed the data: %+v", data)
+	}
+}
+
+func TestQueryCostDownsample(t *testing.T) {
+	ms := &metricstore.MemoryStore{Metrics: map[string]metricstore.MetricConfig{
+		"cpu_load": {Frequency: 60},
+	}}
+	req := &APIQueryRequest{Cluster: "fritz", From: 0, To: 6000}
+
+	tests := []struct {
+		downsample string
+		points     int64
+	}{
+		{"", 6000/600 + 1},
+		{"lttb", 6000/600 + 1},
+		// Read at the metric frequency before downsampling.
+		{"avg", 6000/60 + 1},
+	}
+	for _, tt := range tests {
+		cost := queryCost{}
+		cost.add(ms, req, []APIQuery{{Metric: "cpu_load", Hostname: "f0101", Resolution: 600, Downsample: tt.downsample}})
+		if cost.Points != tt.points {
+			t.Errorf("downsample %q: %d points, want %d", tt.downsample, cost.Points, tt.points)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// This file implements the contract of the Grafana JSON datasource (the
+// "SimpleJSON" API, also understood by the Infinity datasource) below
+// /api/grafana/, so metric data can be plotted in Grafana directly.
+
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+)
+
+type GrafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// GrafanaTargetPayload selects the series of a target. Grafana sends it as
+// `payload` (JSON datasource) or `data` (SimpleJSON).
+type GrafanaTargetPayload struct {
+	Cluster string `json:"cluster"`
+	// Single host, or list of hosts. If both are empty, all hosts of the
+	// cluster are queried.
+	Hostname    string       `json:"host"`
+	Hostnames   []string     `json:"hosts"`
+	Type        *string      `json:"type,omitempty"`
+	SubType     *string      `json:"subtype,omitempty"`
+	TypeIds     []string     `json:"type-ids,omitempty"`
+	SubTypeIds  []string     `json:"subtype-ids,omitempty"`
+	ScaleFactor schema.Float `json:"scale-by,omitempty" swaggertype:"number"`
+	Aggregate   bool         `json:"aggreg"`
+	// Downsample function, see APIQuery
+	Downsample string `json:"downsample,omitempty"`
+	// Unit the values are converted to, see APIQuery
+	TargetUnit string `json:"target-unit,omitempty"`
+}
+
+type GrafanaTarget struct {
+	// Metric name
+	Target  string                `json:"target"`
+	RefID   string                `json:"refId"`
+	Hide    bool                  `json:"hide"`
+	Payload *GrafanaTargetPayload `json:"payload,omitempty"`
+	Data    *GrafanaTargetPayload `json:"data,omitempty"`
+}
+
+type GrafanaQueryRequest struct {
+	Range         GrafanaRange    `json:"range"`
+	IntervalMs    int64           `json:"intervalMs"`
+	MaxDataPoints int64           `json:"maxDataPoints"`
+	Targets       []GrafanaTarget `json:"targets"`
+}
+
+// GrafanaSeries is a time series frame. Each datapoint is a pair of value
+// and Unix timestamp in milliseconds.
+type GrafanaSeries struct {
+	Target     string         `json:"target"`
+	RefID      string         `json:"refId,omitempty"`
+	Datapoints []GrafanaPoint `json:"datapoints" swaggertype:"array,array,number"`
+}
+
+type GrafanaPoint struct {
+	Value     schema.Float
+	Timestamp int64
+}
+
+func (p GrafanaPoint) MarshalJSON() ([]byte, error) {
+	buf := make([]byte, 0, 32)
+	buf = append(buf, '[')
+	if p.Value.IsNaN() {
+		buf = append(buf, "null"...)
+	} else {
+		buf = strconv.AppendFloat(buf, float64(p.Value), 'f', -1, 64)
+	}
+	buf = append(buf, ',')
+	buf = strconv.AppendInt(buf, p.Timestamp, 10)
+	return append(buf, ']'), nil
+}
+
+type GrafanaSearchRequest struct {
+	Target string `json:"target"`
+}
+
+// grafanaHealth godoc
+// @summary Grafana datasource connection test
+// @tags grafana
+// @description Answers the connection test of the Grafana JSON datasource.
+// @success     200            {string} string  "ok"
+// @failure     401            {object} ErrorResponse       "Unauthorized"
+// @failure     403            {object} ErrorResponse       "Forbidden"
+// @security    ApiKeyAuth
+// @router      /grafana/ [get]
+func grafanaHealth(rw http.ResponseWriter, r *http.Request) {
+	rw.WriteHeader(http.StatusOK)
+}
+
+// grafanaSearch godoc
+// @summary Grafana datasource metric search
+// @tags grafana
+// @description Returns the names of all configured metrics that contain the
+// search string, for the metric selection of the Grafana query editor.
+// @accept      json
+// @produce     json
+// @param       request body     GrafanaSearchRequest  false "Search string"
+// @success     200            {array}  string
+// @failure     400            {object} ErrorResponse       "Bad Request"
+// @failure     401            {object} ErrorResponse       "Unauthorized"
+// @failure     403            {object} ErrorResponse       "Forbidden"
+// @security    ApiKeyAuth
+// @router      /grafana/search [post]
+func grafanaSearch(rw http.ResponseWriter, r *http.Request) {
+	req := GrafanaSearchRequest{}
+	if err := json.NewDecoder(limitBody(rw, r)).Decode(&req); err != nil && r.ContentLength != 0 {
+		handleError(fmt.Errorf("parsing request body failed: %w", err), bodyErrorStatus(err), rw)
+		return
+	}
+
+	ms := metricstore.GetMemoryStore()
+	names := make([]string, 0, len(ms.Metrics))
+	for name := range metricConfigs(ms) {
+		if strings.Contains(name, req.Target) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	rw.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(names); err != nil {
+		cclog.Errorf("Failed to encode search response: %v", err)
+	}
+}
+
+// grafanaQuery godoc
+// @summary Grafana datasource query
+// @tags grafana
+// @description Returns one time series per host (and type id, if not
+// aggregated) for every target. The metric is given as `target`, the
+// selection as `payload` (or `data`).
+// @accept      json
+// @produce     json
+// @param       request body     GrafanaQueryRequest  true "Grafana query"
+// @success     200            {array}  GrafanaSeries
+// @header      200            {string} X-Query-Cost      "Selectors, data points and time range of the request"
+// @failure     400            {object} ErrorResponse       "Bad Request"
+// @failure     401            {object} ErrorResponse       "Unauthorized"
+// @failure     403            {object} ErrorResponse       "Forbidden"
+// @failure     413            {object} ErrorResponse       "Request body too large"
+// @failure     422            {object} ErrorResponse       "Query exceeds the configured limits"
+// @failure     500            {object} ErrorResponse       "Internal Server Error"
+// @security    ApiKeyAuth
+// @router      /grafana/query [post]
+func grafanaQuery(rw http.ResponseWriter, r *http.Request) {
+	req := GrafanaQueryRequest{}
+	if err := json.NewDecoder(limitBody(rw, r)).Decode(&req); err != nil {
+		handleError(fmt.Errorf("parsing request body failed: %w", err), bodyErrorStatus(err), rw)
+		return
+	}
+
+	from, to := req.Range.From.Unix(), req.Range.To.Unix()
+	if from > to {
+		handleError(fmt.Errorf("invalid time range"), http.StatusBadRequest, rw)
+		return
+	}
+
+	ms := metricstore.GetMemoryStore()
+	targets, cost := []*grafanaTargetQueries{}, queryCost{}
+	for _, target := range req.Targets {
+		if target.Hide || target.Target == "" {
+			continue
+		}
+
+		tq, err := grafanaBuildQueries(ms, &req, &target, from, to)
+		if err != nil {
+			handleError(err, http.StatusBadRequest, rw)
+			return
+		}
+		cost.add(ms, tq.req, tq.queries)
+		targets = append(targets, tq)
+	}
+
+	rw.Header().Set(queryCostHeader, cost.String())
+	if err := cost.check(); err != nil {
+		handleError(err, http.StatusUnprocessableEntity, rw)
+		return
+	}
+
+	series := []GrafanaSeries{}
+	for _, tq := range targets {
+		s, err := grafanaReadTarget(r, ms, tq)
+		if err != nil {
+			handleError(err, http.StatusBadRequest, rw)
+			return
+		}
+		series = append(series, s...)
+	}
+
+	rw.Header().Add("Content-Type", "application/json")
+	bw := bufio.NewWriter(rw)
+	defer bw.Flush()
+	if err := json.NewEncoder(bw).Encode(series); err != nil {
+		cclog.Errorf("Failed to encode query response: %v", err)
+	}
+}
+
+// grafanaResolution returns the resolution for a metric, so that the series
+// fits into the interval and number of points requested by Grafana. The
+// result is a multiple of the metric frequency, as required for resampling.
+func grafanaResolution(req *GrafanaQueryRequest, frequency, from, to int64) int64 {
+	res := req.IntervalMs / 1000
+	if req.MaxDataPoints > 0 {
+		res = max(res, (to-from+req.MaxDataPoints-1)/req.MaxDataPoints)
+	}
+	if res <= frequency {
+		return frequency
+	}
+	return (res + frequency - 1) / frequency * frequency
+}
+
+// grafanaTargetQueries holds the queries a target is split into and the
+// names of the resulting series.
+type grafanaTargetQueries struct {
+	refID   string
+	req     *APIQueryRequest
+	queries []APIQuery
+	names   []string
+}
+
+// grafanaBuildQueries splits a target into queries with a single selector
+// each, so every result can be named after its host and type id.
+func grafanaBuildQueries(ms *metricstore.MemoryStore, req *GrafanaQueryRequest, target *GrafanaTarget, from, to int64) (*grafanaTargetQueries, error) {
+	mc, ok := metricConfig(ms, target.Target)
+	if !ok {
+		return nil, fmt.Errorf("unknown metric '%s' in target %s", target.Target, target.RefID)
+	}
+
+	p := target.Payload
+	if p == nil {
+		p = target.Data
+	}
+	if p == nil || p.Cluster == "" {
+		return nil, fmt.Errorf("target %s: cluster is required in payload", target.RefID)
+	}
+	if err := checkDownsample(p.Downsample); err != nil {
+		return nil, fmt.Errorf("target %s: %w", target.RefID, err)
+	}
+	// Without aggreg, there is one series per type id, so an empty list of
+	// ids would silently return no series at all.
+	if !p.Aggregate && p.Type != nil {
+		if len(p.TypeIds) == 0 {
+			return nil, fmt.Errorf("target %s: type-ids are required with type unless aggreg is set", target.RefID)
+		}
+		if p.SubType != nil && len(p.SubTypeIds) == 0 {
+			return nil, fmt.Errorf("target %s: subtype-ids are required with subtype unless aggreg is set", target.RefID)
+		}
+	}
+	if p.TargetUnit != "" {
+		if _, err := unitConverter(target.Target, p.TargetUnit); err != nil {
+			return nil, fmt.Errorf("target %s: %w", target.RefID, err)
+		}
+	}
+
+	hosts := p.Hostnames
+	if p.Hostname != "" {
+		hosts = append(hosts, p.Hostname)
+	}
+	if len(hosts) == 0 {
+		hosts = ms.ListChildren([]string{p.Cluster})
+		slices.Sort(hosts)
+	}
+
+	queries, names := []APIQuery{}, []string{}
+	for _, host := range hosts {
+		q := APIQuery{
+			Metric:      target.Target,
+			Hostname:    host,
+			Type:        p.Type,
+			SubType:     p.SubType,
+			TypeIds:     p.TypeIds,
+			SubTypeIds:  p.SubTypeIds,
+			ScaleFactor: p.ScaleFactor,
+			Aggregate:   p.Aggregate,
+			Downsample:  p.Downsample,
+			TargetUnit:  p.TargetUnit,
+			Resolution:  grafanaResolution(req, mc.Frequency, from, to),
+		}
+		if q.Aggregate || q.Type == nil {
+			queries = append(queries, q)
+			names = append(names, target.Target+" "+host)
+			continue
+		}
+
+		for _, id := range p.TypeIds {
+			qt := q
+			qt.TypeIds = []string{id}
+			if qt.SubType == nil {
+				queries = append(queries, qt)
+				names = append(names, fmt.Sprintf("%s %s %s%s", target.Target, host, *p.Type, id))
+				continue
+			}
+			for _, sid := range p.SubTypeIds {
+				qs := qt
+				qs.SubTypeIds = []string{sid}
+				queries = append(queries, qs)
+				names = append(names, fmt.Sprintf("%s %s %s%s %s%s", target.Target, host, *p.Type, id, *p.SubType, sid))
+			}
+		}
+	}
+
+	return &grafanaTargetQueries{
+		refID:   target.RefID,
+		req:     &APIQueryRequest{Cluster: p.Cluster, From: from, To: to, WithData: true},
+		queries: queries,
+		names:   names,
+	}, nil
+}
+
+// grafanaReadTarget reads all series selected by a target.
+func grafanaReadTarget(r *http.Request, ms *metricstore.MemoryStore, tq *grafanaTargetQueries) ([]GrafanaSeries, error) {
+	results, err := readQueries(r.Context(), ms, tq.req, tq.queries)
+	if err != nil {
+		return nil, err
+	}
+
+	series := make([]GrafanaSeries, 0, len(results))
+	for i, res := range results {
+		if len(res) == 0 {
+			continue
+		}
+		data := res[0]
+		if data.Error != nil {
+			cclog.Warnf("grafana query for '%s' failed: %s", tq.names[i], *data.Error)
+			continue
+		}
+
+		s := GrafanaSeries{
+			Target:     tq.names[i],
+			RefID:      tq.refID,
+			Datapoints: make([]GrafanaPoint, len(data.Data)),
+		}
+		for j, v := range data.Data {
+			s.Datapoints[j] = GrafanaPoint{
+				Value:     v,
+				Timestamp: (data.From + int64(j)*data.Resolution) * 1000,
+			}
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
+
+// grafanaAnnotations godoc
+// @summary Grafana datasource annotations
+// @tags grafana
+// @description The metric store has no events, so no annotations are
+// returned. The endpoint exists so that Grafana does not report an error.
+// @accept      json
+// @produce     json
+// @success     200            {array}  string
+// @failure     401            {object} ErrorResponse       "Unauthorized"
+// @failure     403            {object} ErrorResponse       "Forbidden"
+// @security    ApiKeyAuth
+// @router      /grafana/annotations [post]
+func grafanaAnnotations(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Add("Content-Type", "application/json")
+	rw.Write([]byte("[]\n"))
+}
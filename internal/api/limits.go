@@ -0,0 +1,92 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
+)
+
+// queryCostHeader reports the cost of a query request to the client, e.g.
+// `selectors=24; points=17304; range=43200`.
+const queryCostHeader = "X-Query-Cost"
+
+// queryCost is the amount of work a query request causes.
+type queryCost struct {
+	Selectors int
+	Points    int64
+	TimeRange int64 // Seconds
+}
+
+func (c *queryCost) String() string {
+	return fmt.Sprintf("selectors=%d; points=%d; range=%d", c.Selectors, c.Points, c.TimeRange)
+}
+
+// add accounts for the selectors and data points of queries over the time
+// range of req. The number of points is estimated from the metric frequency
+// and the requested resolution.
+func (c *queryCost) add(ms *metricstore.MemoryStore, req *APIQueryRequest, queries []APIQuery) {
+	c.TimeRange = max(c.TimeRange, req.To-req.From)
+	for _, q := range queries {
+		n := len(buildSelectors(req.Cluster, q))
+		c.Selectors += n
+
+		mc, ok := metricConfig(ms, q.Metric)
+		if !ok || req.To < req.From {
+			continue
+		}
+		// Downsample functions other than LTTB read the data at the metric
+		// frequency, see readSelector.
+		res := mc.Frequency
+		if _, custom := downsamplers[q.Downsample]; !custom {
+			res = max(res, q.Resolution)
+		}
+		c.Points += int64(n) * ((req.To-req.From)/res + 1)
+	}
+}
+
+// check returns an error if the cost exceeds one of the configured limits.
+func (c *queryCost) check() error {
+	limits := &config.Keys.QueryLimits
+	if limits.MaxSelectors > 0 && c.Selectors > limits.MaxSelectors {
+		return fmt.Errorf("query expands to %d selectors, the limit is %d", c.Selectors, limits.MaxSelectors)
+	}
+	if limits.MaxPoints > 0 && c.Points > limits.MaxPoints {
+		return fmt.Errorf("query returns up to %d data points, the limit is %d", c.Points, limits.MaxPoints)
+	}
+	if limits.MaxTimeRange != "" {
+		d, _ := time.ParseDuration(limits.MaxTimeRange)
+		if d > 0 && time.Duration(c.TimeRange)*time.Second > d {
+			return fmt.Errorf("query time range of %s exceeds the limit of %s",
+				time.Duration(c.TimeRange)*time.Second, d)
+		}
+	}
+	return nil
+}
+
+// limitBody applies the `max-body-size` limit to the request body.
+func limitBody(rw http.ResponseWriter, r *http.Request) io.Reader {
+	if limit := config.Keys.QueryLimits.MaxBodySize; limit > 0 {
+		return http.MaxBytesReader(rw, r.Body, limit)
+	}
+	return r.Body
+}
+
+// bodyErrorStatus returns 413 if decoding the request body failed because
+// it exceeds `max-body-size`, and 400 otherwise.
+func bodyErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -7,20 +7,27 @@ package api
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"net/http"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
 	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
 	"github.com/ClusterCockpit/cc-lib/v2/schema"
 	"github.com/ClusterCockpit/cc-lib/v2/util"
 	"github.com/ClusterCockpit/cc-line-protocol/v2/lineprotocol"
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
 )
 
 // ErrorResponse model
@@ -28,6 +35,8 @@ type ErrorResponse struct {
 	// Statustext of Errorcode
 	Status string `json:"status"`
 	Error  string `json:"error"` // Error Message
+	// Invalid fields of the request, if any
+	Fields []FieldError `json:"fields,omitempty"`
 }
 
 // DefaultAPIResponse model
@@ -39,12 +48,17 @@ type DefaultAPIResponse struct {
 // It logs the error at WARN level and ensures proper Content-Type headers are set.
 func handleError(err error, statusCode int, rw http.ResponseWriter) {
 	cclog.Warnf("REST ERROR : %s", err.Error())
-	rw.Header().Add("Content-Type", "application/json")
+	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(statusCode)
-	if err := json.NewEncoder(rw).Encode(ErrorResponse{
+	resp := ErrorResponse{
 		Status: http.StatusText(statusCode),
 		Error:  err.Error(),
-	}); err != nil {
+	}
+	var ve *validationError
+	if errors.As(err, &ve) {
+		resp.Fields = ve.fields
+	}
+	if err := json.NewEncoder(rw).Encode(resp); err != nil {
 		cclog.Errorf("Failed to encode error response: %v", err)
 	}
 }
@@ -55,9 +69,19 @@ type APIMetricData struct {
 	From       int64             `json:"from"`
 	To         int64             `json:"to"`
 	Resolution int64             `json:"resolution"`
+	Downsample string            `json:"downsample,omitempty"`
+	Unit       string            `json:"unit,omitempty"`
 	Avg        schema.Float      `json:"avg"                  swaggertype:"number"`
 	Min        schema.Float      `json:"min"                  swaggertype:"number"`
 	Max        schema.Float      `json:"max"                  swaggertype:"number"`
+	// The following statistics are only set if requested in `stats`.
+	Median      *schema.Float           `json:"median,omitempty"      swaggertype:"number"`
+	Percentiles map[string]schema.Float `json:"percentiles,omitempty" swaggertype:"object,number"`
+	Stddev      *schema.Float           `json:"stddev,omitempty"      swaggertype:"number"`
+	Count       *int                    `json:"count,omitempty"`
+	NaNCount    *int                    `json:"nan-count,omitempty"`
+
+	labels seriesLabels
 }
 
 // TODO: Optimize this, just like the stats endpoint!
@@ -93,13 +117,22 @@ func (data *APIMetricData) ScaleBy(f schema.Float) {
 	data.Avg *= f
 	data.Min *= f
 	data.Max *= f
+	if data.Median != nil {
+		*data.Median *= f
+	}
+	if data.Stddev != nil {
+		*data.Stddev *= f
+	}
+	for name := range data.Percentiles {
+		data.Percentiles[name] *= f
+	}
 	for i := 0; i < len(data.Data); i++ {
 		data.Data[i] *= f
 	}
 }
 
 func (data *APIMetricData) PadDataWithNull(ms *metricstore.MemoryStore, from, to int64, metric string) {
-	minfo, ok := ms.Metrics[metric]
+	minfo, ok := metricConfig(ms, metric)
 	if !ok {
 		return
 	}
@@ -126,6 +159,14 @@ type APIQueryRequest struct {
 	WithStats   bool       `json:"with-stats"`
 	WithData    bool       `json:"with-data"`
 	WithPadding bool       `json:"with-padding"`
+	// Additional statistics per series: "median", "stddev", "count",
+	// "nan-count" and percentiles as "p<N>", e.g. "p10", "p90", "p99".
+	Stats []string `json:"stats,omitempty"`
+	// If set, the metrics in `for-all-nodes` are combined across all nodes
+	// into a single series using this operator (see APIQuery.Operator).
+	ForAllNodesOperator string `json:"for-all-nodes-operator,omitempty"`
+
+	stats *statsSpec
 }
 
 type APIQueryResponse struct {
@@ -143,6 +184,18 @@ type APIQuery struct {
 	SubTypeIds  []string     `json:"subtype-ids,omitempty"`
 	ScaleFactor schema.Float `json:"scale-by,omitempty" swaggertype:"number"`
 	Aggregate   bool         `json:"aggreg"`
+	// Further hosts, read in addition to `host`
+	Hosts []string `json:"hosts,omitempty"`
+	// Combine all series of the query (all hosts and type ids) into a
+	// single one: "sum", "avg", "min", "max" or "stddev"
+	Operator string `json:"operator,omitempty"`
+	// Function used to reduce the data to the requested resolution: "avg",
+	// "min", "max", "last", "sum" or "lttb" (default)
+	Downsample string `json:"downsample,omitempty"`
+	// Unit the values are converted to, e.g. "GB/s". Requires a unit of the
+	// metric in the metrics section. The conversion is applied before
+	// scale-by.
+	TargetUnit string `json:"target-unit,omitempty"`
 }
 
 // handleQuery godoc
@@ -150,35 +203,74 @@ type APIQuery struct {
 // @tags query
 // @description This endpoint allows the users to retrieve data from the
 // in-memory database. The CCMS will return data in JSON format for the
-// specified interval requested by the user
+// specified interval requested by the user. The query is sent as JSON body
+// (GET or POST), or, for simple queries, as URL parameters: if `metric` is
+// given, one query per host and metric is built from the parameters
+// `cluster`, `host`, `metric`, `from`, `to` and optionally `resolution`,
+// `type`, `type-ids`, `aggreg`, `downsample`, `operator`, `stats`,
+// `with-stats`, `with-data` and `with-padding`.
 // @accept      json
-// @produce     json
-// @param       request body     APIQueryRequest  true "API query payload object"
+// @produce     json,text/csv,application/vnd.apache.arrow.stream
+// @param       request body     APIQueryRequest  false "API query payload object"
+// @param       cluster query    string           false "Cluster (URL form)"
+// @param       host    query    []string         false "Hosts, repeated or comma-separated (URL form)"
+// @param       metric  query    []string         false "Metrics, repeated or comma-separated (URL form, replaces the body)"
+// @param       from    query    int              false "Start of the time range (URL form)"
+// @param       to      query    int              false "End of the time range (URL form)"
+// @param       stream  query    bool             false "Stream the results of each query as soon as they are read"
+// @param       Accept-Encoding header string     false "Compress the response with zstd or gzip"
 // @success     200            {object} APIQueryResponse  "API query response object"
-// @failure     400            {object} ErrorResponse       "Bad Request"
+// @header      200            {string} X-Query-Cost      "Selectors, data points and time range of the request"
+// @failure     400            {object} ErrorResponse       "Bad Request, with the invalid fields"
 // @failure     401   		   {object} ErrorResponse       "Unauthorized"
 // @failure     403            {object} ErrorResponse       "Forbidden"
+// @failure     413            {object} ErrorResponse       "Request body too large"
+// @failure     422            {object} ErrorResponse       "Query exceeds the configured limits"
 // @failure     500            {object} ErrorResponse       "Internal Server Error"
 // @security    ApiKeyAuth
 // @router      /query/ [get]
+// @router      /query/ [post]
 func handleQuery(rw http.ResponseWriter, r *http.Request) {
-	var err error
+	rw, done := compressResponse(rw, r)
+	defer done()
+
 	ver := r.URL.Query().Get("version")
 	if ver == "" {
 		ver = "v2"
 	}
 	req := APIQueryRequest{WithStats: true, WithData: true, WithPadding: true}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		handleError(err, http.StatusBadRequest, rw)
+	if r.URL.Query().Has("metric") {
+		if err := parseQueryParams(r.URL.Query(), &req); err != nil {
+			handleError(err, http.StatusBadRequest, rw)
+			return
+		}
+	} else if err := decodeQueryRequest(limitBody(rw, r), &req); err != nil {
+		handleError(err, bodyErrorStatus(err), rw)
 		return
 	}
 
 	ms := metricstore.GetMemoryStore()
+	if err := validateQueryRequest(ms, &req); err != nil {
+		handleError(err, http.StatusBadRequest, rw)
+		return
+	}
 
 	response := APIQueryResponse{
 		Results: make([][]APIMetricData, 0, len(req.Queries)),
 	}
-	if req.ForAllNodes != nil {
+	if req.ForAllNodes != nil && req.ForAllNodesOperator != "" {
+		nodes := ms.ListChildren([]string{req.Cluster})
+		slices.Sort(nodes)
+		for _, metric := range req.ForAllNodes {
+			q := APIQuery{
+				Metric:   metric,
+				Hosts:    nodes,
+				Operator: req.ForAllNodesOperator,
+			}
+			req.Queries = append(req.Queries, q)
+			response.Queries = append(response.Queries, q)
+		}
+	} else if req.ForAllNodes != nil {
 		nodes := ms.ListChildren([]string{req.Cluster})
 		for _, node := range nodes {
 			for _, metric := range req.ForAllNodes {
@@ -192,99 +284,285 @@ func handleQuery(rw http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	for _, query := range req.Queries {
-		sels := make([]util.Selector, 0, 1)
-		if query.Aggregate || query.Type == nil {
-			sel := util.Selector{{String: req.Cluster}, {String: query.Hostname}}
-			if query.Type != nil {
-				if len(query.TypeIds) == 1 {
-					sel = append(sel, util.SelectorElement{String: *query.Type + query.TypeIds[0]})
-				} else {
-					ids := make([]string, len(query.TypeIds))
-					for i, id := range query.TypeIds {
-						ids[i] = *query.Type + id
-					}
-					sel = append(sel, util.SelectorElement{Group: ids})
+	cost := queryCost{}
+	cost.add(ms, &req, req.Queries)
+	rw.Header().Set(queryCostHeader, cost.String())
+	if err := cost.check(); err != nil {
+		handleError(err, http.StatusUnprocessableEntity, rw)
+		return
+	}
+
+	if format := tabularFormat(r); format != "" {
+		// Every row carries its timestamp, padding is not needed.
+		req.WithData, req.WithPadding = true, false
+		results, err := readQueries(r.Context(), ms, &req, req.Queries)
+		if err != nil {
+			cclog.Warnf("query aborted: %s", err.Error())
+			return
+		}
+		if err := writeTabularResponse(rw, format, &req, req.Queries, results); err != nil {
+			cclog.Errorf("Failed to encode %s query response: %v", format, err)
+		}
+		return
+	}
+
+	if stream, _ := strconv.ParseBool(r.URL.Query().Get("stream")); stream {
+		streamQueryResponse(rw, r, ms, &req, response.Queries)
+		return
+	}
+
+	results, err := readQueries(r.Context(), ms, &req, req.Queries)
+	if err != nil {
+		cclog.Warnf("query aborted: %s", err.Error())
+		return
+	}
+	response.Results = results
+
+	rw.Header().Set("Content-Type", "application/json")
+	bw := bufio.NewWriter(rw)
+	defer bw.Flush()
+	if err := json.NewEncoder(bw).Encode(response); err != nil {
+		log.Print(err)
+		return
+	}
+}
+
+// buildSelectors returns the selectors that have to be read to answer query.
+// Per host, aggregated queries (or queries without a type) result in a single
+// selector, all other queries in one selector per type-id/subtype-id
+// combination.
+func buildSelectors(cluster string, query APIQuery) []util.Selector {
+	if len(query.Hosts) == 0 {
+		return buildHostSelectors(cluster, query.Hostname, query)
+	}
+
+	sels := []util.Selector{}
+	if query.Hostname != "" {
+		sels = append(sels, buildHostSelectors(cluster, query.Hostname, query)...)
+	}
+	for _, host := range query.Hosts {
+		sels = append(sels, buildHostSelectors(cluster, host, query)...)
+	}
+	return sels
+}
+
+func buildHostSelectors(cluster, host string, query APIQuery) []util.Selector {
+	sels := make([]util.Selector, 0, 1)
+	if query.Aggregate || query.Type == nil {
+		sel := util.Selector{{String: cluster}, {String: host}}
+		if query.Type != nil {
+			if len(query.TypeIds) == 1 {
+				sel = append(sel, util.SelectorElement{String: *query.Type + query.TypeIds[0]})
+			} else {
+				ids := make([]string, len(query.TypeIds))
+				for i, id := range query.TypeIds {
+					ids[i] = *query.Type + id
 				}
+				sel = append(sel, util.SelectorElement{Group: ids})
+			}
 
-				if query.SubType != nil {
-					if len(query.SubTypeIds) == 1 {
-						sel = append(sel, util.SelectorElement{String: *query.SubType + query.SubTypeIds[0]})
-					} else {
-						ids := make([]string, len(query.SubTypeIds))
-						for i, id := range query.SubTypeIds {
-							ids[i] = *query.SubType + id
-						}
-						sel = append(sel, util.SelectorElement{Group: ids})
+			if query.SubType != nil {
+				if len(query.SubTypeIds) == 1 {
+					sel = append(sel, util.SelectorElement{String: *query.SubType + query.SubTypeIds[0]})
+				} else {
+					ids := make([]string, len(query.SubTypeIds))
+					for i, id := range query.SubTypeIds {
+						ids[i] = *query.SubType + id
 					}
+					sel = append(sel, util.SelectorElement{Group: ids})
 				}
 			}
-			sels = append(sels, sel)
-		} else {
-			for _, typeID := range query.TypeIds {
-				if query.SubType != nil {
-					for _, subTypeID := range query.SubTypeIds {
-						sels = append(sels, util.Selector{
-							{String: req.Cluster},
-							{String: query.Hostname},
-							{String: *query.Type + typeID},
-							{String: *query.SubType + subTypeID},
-						})
-					}
-				} else {
+		}
+		sels = append(sels, sel)
+	} else {
+		for _, typeID := range query.TypeIds {
+			if query.SubType != nil {
+				for _, subTypeID := range query.SubTypeIds {
 					sels = append(sels, util.Selector{
-						{String: req.Cluster},
-						{String: query.Hostname},
+						{String: cluster},
+						{String: host},
 						{String: *query.Type + typeID},
+						{String: *query.SubType + subTypeID},
 					})
 				}
+			} else {
+				sels = append(sels, util.Selector{
+					{String: cluster},
+					{String: host},
+					{String: *query.Type + typeID},
+				})
 			}
 		}
+	}
 
-		// log.Printf("query: %#v\n", query)
-		// log.Printf("sels: %#v\n", sels)
-
-		res := make([]APIMetricData, 0, len(sels))
-		for _, sel := range sels {
-			data := APIMetricData{}
-
-			data.Data, data.From, data.To, data.Resolution, err = ms.Read(sel, query.Metric, req.From, req.To, query.Resolution)
-			if err != nil {
-				// Skip Error If Just Missing Host or Metric, Continue
-				// Empty Return For Metric Handled Gracefully By Frontend
-				if err != metricstore.ErrNoHostOrMetric {
-					msg := err.Error()
-					data.Error = &msg
-					res = append(res, data)
-				} else {
-					cclog.Warnf("failed to fetch '%s' from host '%s' (cluster: %s): %s", query.Metric, query.Hostname, req.Cluster, err.Error())
-				}
-				continue
-			}
+	return sels
+}
+
+// readQueries reads all selectors of all queries from the memory store and
+// applies the post-processing (stats, scaling, padding) requested in req.
+// Selectors are evaluated concurrently on a bounded pool of workers (see
+// queryWorkers); the results are ordered like queries and, within a query,
+// like its selectors. If ctx is cancelled before all selectors have been
+// read, the context error is returned.
+func readQueries(ctx context.Context, ms *metricstore.MemoryStore, req *APIQueryRequest, queries []APIQuery) ([][]APIMetricData, error) {
+	type task struct {
+		query, sel int
+	}
+
+	sels := make([][]util.Selector, len(queries))
+	slots := make([][]APIMetricData, len(queries))
+	found := make([][]bool, len(queries))
+	ntasks := 0
+	for i, query := range queries {
+		sels[i] = buildSelectors(req.Cluster, query)
+		slots[i] = make([]APIMetricData, len(sels[i]))
+		found[i] = make([]bool, len(sels[i]))
+		ntasks += len(sels[i])
+	}
+
+	read := func(t task) {
+		slots[t.query][t.sel], found[t.query][t.sel] = readSelector(ms, req, queries[t.query], sels[t.query][t.sel])
+	}
 
-			if req.WithStats {
-				data.AddStats()
+	if workers := min(queryWorkers(), ntasks); workers <= 1 {
+		for i := range sels {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			for j := range sels[i] {
+				read(task{query: i, sel: j})
 			}
-			if query.ScaleFactor != 0 {
-				data.ScaleBy(query.ScaleFactor)
+		}
+	} else {
+		var wg sync.WaitGroup
+		tasks := make(chan task)
+		for range workers {
+			wg.Go(func() {
+				for t := range tasks {
+					read(t)
+				}
+			})
+		}
+
+	dispatch:
+		for i := range sels {
+			for j := range sels[i] {
+				select {
+				case tasks <- task{query: i, sel: j}:
+				case <-ctx.Done():
+					break dispatch
+				}
 			}
-			if req.WithPadding {
-				data.PadDataWithNull(ms, req.From, req.To, query.Metric)
+		}
+		close(tasks)
+		wg.Wait()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([][]APIMetricData, len(queries))
+	for i := range slots {
+		res := make([]APIMetricData, 0, len(slots[i]))
+		for j, data := range slots[i] {
+			if found[i][j] {
+				res = append(res, data)
 			}
-			if !req.WithData {
-				data.Data = nil
+		}
+		if queries[i].Operator != "" && len(res) > 0 {
+			data := combineSeries(queries[i].Operator, res)
+			data.labels = mergeLabels(res)
+			if data.Error == nil {
+				postProcess(ms, req, queries[i], &data)
 			}
-			res = append(res, data)
+			res = []APIMetricData{data}
 		}
-		response.Results = append(response.Results, res)
+		results[i] = res
 	}
+	return results, nil
+}
 
-	rw.Header().Set("Content-Type", "application/json")
-	bw := bufio.NewWriter(rw)
-	defer bw.Flush()
-	if err := json.NewEncoder(bw).Encode(response); err != nil {
-		log.Print(err)
-		return
+// queryWorkers returns the number of concurrent workers used to read the
+// selectors of a single query request.
+func queryWorkers() int {
+	if config.Keys.QueryWorkers > 0 {
+		return config.Keys.QueryWorkers
+	}
+	return runtime.NumCPU()
+}
+
+// readSelector reads a single selector of query. The second return value is
+// false if the host or metric does not exist and the result should be skipped.
+// The post-processing of queries with an operator is done after the series
+// have been combined.
+func readSelector(ms *metricstore.MemoryStore, req *APIQueryRequest, query APIQuery, sel util.Selector) (APIMetricData, bool) {
+	var err error
+	data := APIMetricData{labels: selectorLabels(query, sel)}
+	if s, ok := config.GetMetricSchema(query.Metric); ok {
+		data.Unit = s.Unit
+	}
+
+	// Downsample functions other than LTTB are applied to the data read at
+	// the metric frequency.
+	fn, custom := downsamplers[query.Downsample]
+	resolution := query.Resolution
+	if custom {
+		resolution = 0
+	}
+
+	if config.IsReserved(query.Metric) {
+		return data, false
+	}
+	data.Data, data.From, data.To, data.Resolution, err = ms.Read(sel, config.MetricSlot(query.Metric), req.From, req.To, resolution)
+	if err != nil {
+		// Skip Error If Just Missing Host or Metric, Continue
+		// Empty Return For Metric Handled Gracefully By Frontend
+		if err != metricstore.ErrNoHostOrMetric {
+			msg := err.Error()
+			data.Error = &msg
+			return data, true
+		}
+		cclog.Warnf("failed to fetch '%s' from host '%s' (cluster: %s): %s", query.Metric, sel[1].String, req.Cluster, err.Error())
+		return data, false
+	}
+
+	if custom {
+		data.downsample(fn, query.Resolution)
+	}
+	data.Downsample = query.Downsample
+	if !custom {
+		data.Downsample = "lttb"
+	}
+	if query.TargetUnit != "" {
+		// validateQueryRequest has checked the conversion already.
+		if conv, err := unitConverter(query.Metric, query.TargetUnit); err == nil {
+			data.convertUnit(conv, query.TargetUnit)
+		}
+	}
+	if query.Operator == "" && data.Error == nil {
+		postProcess(ms, req, query, &data)
+	}
+	return data, true
+}
+
+// postProcess applies the stats, scaling and padding requested in req to a
+// series of query.
+func postProcess(ms *metricstore.MemoryStore, req *APIQueryRequest, query APIQuery, data *APIMetricData) {
+	if req.WithStats {
+		data.AddStats()
+	}
+	if req.stats != nil {
+		data.AddExtendedStats(req.stats)
+	}
+	if query.ScaleFactor != 0 {
+		data.ScaleBy(query.ScaleFactor)
+	}
+	if req.WithPadding {
+		data.PadDataWithNull(ms, req.From, req.To, query.Metric)
+	}
+	if !req.WithData {
+		data.Data = nil
 	}
 }
 
@@ -349,15 +627,18 @@ func freeMetrics(rw http.ResponseWriter, r *http.Request) {
 // @accept      plain
 // @produce     json
 // @param       cluster        query string false "If the lines in the body do not have a cluster tag, use this value instead."
-// @success     200            {string} string  "ok"
+// @param       report         query bool   false "Skip invalid lines instead of aborting and return a summary of the write"
+// @param       Content-Encoding header string false "Compression of the body: gzip or zstd"
+// @success     200            {object} WriteReport  "Summary of the write if report=true"
 // @failure     400            {object} ErrorResponse       "Bad Request"
 // @failure     401            {object} ErrorResponse       "Unauthorized"
 // @failure     403            {object} ErrorResponse       "Forbidden"
+// @failure     415            {object} ErrorResponse       "Unsupported Content-Encoding"
 // @failure     500            {object} ErrorResponse       "Internal Server Error"
 // @security    ApiKeyAuth
 // @router      /write/ [post]
 func writeMetrics(rw http.ResponseWriter, r *http.Request) {
-	rw.Header().Add("Content-Type", "application/json")
+	rw.Header().Set("Content-Type", "application/json")
 
 	// Extract the "cluster" query parameter without allocating a url.Values map.
 	cluster := queryParam(r.URL.RawQuery, "cluster")
@@ -366,8 +647,45 @@ func writeMetrics(rw http.ResponseWriter, r *http.Request) {
 	// temporary buffer via io.ReadAll. The line-protocol decoder supports
 	// io.Reader natively, so this avoids the largest heap allocation.
 	ms := metricstore.GetMemoryStore()
-	dec := lineprotocol.NewDecoder(r.Body)
-	if err := metricstore.DecodeLine(dec, ms, cluster); err != nil {
+	zr, err := decompressBody(r)
+	if err != nil {
+		writeDecodeErrors.inc("write")
+		handleError(err, encodingErrorStatus(err), rw)
+		return
+	}
+	defer zr.Close()
+	body := &lineCounter{r: zr}
+	if report, _ := strconv.ParseBool(queryParam(r.URL.RawQuery, "report")); report {
+		res, err := writeWithReport(body, ms, cluster)
+		writeLines.add(body.lines, "write")
+		writeRejectedLines.add(uint64(res.RejectedCount), "write")
+		if err != nil {
+			writeDecodeErrors.inc("write")
+			cclog.Errorf("/api/write error: %s", err.Error())
+			handleError(err, http.StatusBadRequest, rw)
+			return
+		}
+		if err := json.NewEncoder(rw).Encode(res); err != nil {
+			cclog.Errorf("Failed to encode write report: %v", err)
+		}
+		return
+	}
+
+	var filter *writeFilter
+	var dec *lineprotocol.Decoder
+	if writeFiltered() {
+		filter = newWriteFilter(body, ms, "write", cluster)
+		dec = lineprotocol.NewDecoder(filter)
+	} else {
+		dec = lineprotocol.NewDecoder(body)
+	}
+	err = metricstore.DecodeLine(dec, ms, cluster)
+	if err == nil && filter != nil {
+		err = filter.rejected
+	}
+	writeLines.add(body.lines, "write")
+	if err != nil {
+		writeDecodeErrors.inc("write")
 		cclog.Errorf("/api/write error: %s", err.Error())
 		handleError(err, http.StatusBadRequest, rw)
 		return
@@ -379,9 +697,18 @@ func writeMetrics(rw http.ResponseWriter, r *http.Request) {
 // @summary Debug endpoint
 // @tags debug
 // @description This endpoint allows the users to print the content of
-// nodes/clusters/metrics to review the state of the data.
+// nodes/clusters/metrics to review the state of the data. If any of the
+// limiting parameters is set, only the selected part of the tree is returned
+// as a structured document, otherwise the full dump is streamed.
 // @produce     json
 // @param       selector        query    string            false "Selector"
+// @param       depth           query    int               false "Maximum number of levels below the selected one"
+// @param       limit           query    int               false "Maximum number of children per level"
+// @param       offset          query    int               false "Number of children of the selected level to skip"
+// @param       metric          query    string            false "Comma-separated list of metrics"
+// @param       from            query    int               false "Only buffers with data after this timestamp"
+// @param       to              query    int               false "Only buffers with data before this timestamp"
+// @param       summary         query    bool              false "Report buffer counts, time range and memory instead of buffers"
 // @success     200            {string} string  "Debug dump"
 // @failure     400            {object} ErrorResponse       "Bad Request"
 // @failure     401            {object} ErrorResponse       "Unauthorized"
@@ -390,6 +717,24 @@ func writeMetrics(rw http.ResponseWriter, r *http.Request) {
 // @security    ApiKeyAuth
 // @router      /debug/ [post]
 func debugMetrics(rw http.ResponseWriter, r *http.Request) {
+	opts, limited, err := parseDebugOptions(r)
+	if err != nil {
+		handleError(err, http.StatusBadRequest, rw)
+		return
+	}
+	if limited {
+		res, err := limitedDebugDump(metricstore.GetMemoryStore(), opts)
+		if err != nil {
+			handleError(err, http.StatusBadRequest, rw)
+			return
+		}
+		rw.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(res); err != nil {
+			cclog.Errorf("Failed to encode debug response: %v", err)
+		}
+		return
+	}
+
 	raw := r.URL.Query().Get("selector")
 	rw.Header().Add("Content-Type", "application/json")
 	selector := []string{}
@@ -404,6 +749,45 @@ func debugMetrics(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ReloadResponse lists the changes found when reloading the metric
+// configuration.
+type ReloadResponse struct {
+	// Changes applied to the running memory store
+	Applied []string `json:"applied"`
+	// Changes that only take effect after a restart
+	RestartRequired []string `json:"restart-required"`
+}
+
+// handleReload godoc
+// @summary Reload the metric configuration
+// @tags reload
+// @description This endpoint re-reads the `metrics` section of the config
+// file. Changes of the unit, range and scopes are applied to the
+// running store, as are added metrics that fit a free slot reserved for
+// registered unknown metrics. Other added metrics, removed metrics and
+// changed frequencies or aggregation strategies are reported as requiring a
+// restart. If the configuration is invalid, nothing is changed.
+// @produce     json
+// @success     200            {object} ReloadResponse      "Applied and pending changes"
+// @failure     400            {object} ErrorResponse       "Bad Request"
+// @failure     401            {object} ErrorResponse       "Unauthorized"
+// @failure     403            {object} ErrorResponse       "Forbidden"
+// @failure     500            {object} ErrorResponse       "Internal Server Error"
+// @security    ApiKeyAuth
+// @router      /reload/ [post]
+func reloadMetrics(rw http.ResponseWriter, r *http.Request) {
+	changes, err := config.ReloadMetrics(metricstore.GetMemoryStore())
+	if err != nil {
+		handleError(fmt.Errorf("reloading metric configuration failed: %w", err), http.StatusBadRequest, rw)
+		return
+	}
+
+	rw.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(ReloadResponse(*changes)); err != nil {
+		cclog.Errorf("Failed to encode reload response: %v", err)
+	}
+}
+
 // handleHealthCheck godoc
 // @summary HealthCheck endpoint
 // @tags healthcheck
@@ -447,6 +831,19 @@ func metricsHealth(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// lineCounter counts the newlines read from the underlying reader, which is
+// the number of line-protocol lines received.
+type lineCounter struct {
+	r     io.Reader
+	lines uint64
+}
+
+func (lc *lineCounter) Read(p []byte) (int, error) {
+	n, err := lc.r.Read(p)
+	lc.lines += uint64(bytes.Count(p[:n], []byte{'\n'}))
+	return n, err
+}
+
 // queryParam extracts a single query parameter value from a raw query string
 // without allocating a url.Values map.
 func queryParam(rawQuery, key string) string {
@@ -6,49 +6,283 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
+)
+
+const (
+	defaultBackendTimeout      = 10 * time.Second
+	defaultBackendRetries      = 3
+	defaultBackendRetryDelay   = time.Second
+	defaultBackendTotalTimeout = 20 * time.Second
 )
 
 // BackendNodeProvider implements metricstore.NodeProvider by querying
 // the cc-backend /api/jobs/used_nodes endpoint.
 type BackendNodeProvider struct {
-	backendURL string
-	client     *http.Client
+	backendURL   string
+	client       *http.Client
+	token        string
+	tokenFile    *watchedFile[string]
+	retries      int
+	retryDelay   time.Duration
+	totalTimeout time.Duration
+	maxStale     time.Duration
+
+	// Last successful answer, used for up to maxStale if cc-backend
+	// cannot be reached.
+	lock     sync.Mutex
+	lastTime time.Time
+	last     map[string][]string
 }
 
 // NewBackendNodeProvider creates a new BackendNodeProvider that queries
-// the given cc-backend URL for used nodes information.
-func NewBackendNodeProvider(backendURL string) *BackendNodeProvider {
-	return &BackendNodeProvider{
-		backendURL: backendURL,
-		client: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+// the cc-backend URL of cfg for used nodes information.
+func NewBackendNodeProvider(cfg config.NodeProviderConfig) (*BackendNodeProvider, error) {
+	p := &BackendNodeProvider{
+		backendURL:   strings.TrimSuffix(cfg.URL, "/"),
+		token:        cfg.Token,
+		retries:      defaultBackendRetries,
+		retryDelay:   defaultBackendRetryDelay,
+		totalTimeout: defaultBackendTotalTimeout,
+	}
+	if cfg.Retries != nil {
+		p.retries = *cfg.Retries
+	}
+
+	timeout := defaultBackendTimeout
+	for _, d := range []struct {
+		name, value string
+		target      *time.Duration
+	}{
+		{"timeout", cfg.Timeout, &timeout},
+		{"retry-delay", cfg.RetryDelay, &p.retryDelay},
+		{"total-timeout", cfg.TotalTimeout, &p.totalTimeout},
+		{"max-stale", cfg.MaxStale, &p.maxStale},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing '%s': %w", d.name, err)
+		}
+		*d.target = v
+	}
+
+	if cfg.Token != "" && cfg.TokenFile != "" {
+		return nil, fmt.Errorf("only one of 'token' and 'token-file' can be set")
+	}
+	if cfg.TokenFile != "" {
+		p.tokenFile = &watchedFile[string]{
+			path: cfg.TokenFile,
+			parse: func(raw []byte) (string, error) {
+				token := strings.TrimSpace(string(raw))
+				if token == "" {
+					return "", fmt.Errorf("empty token")
+				}
+				return token, nil
+			},
+		}
+		if _, err := p.tokenFile.get(); err != nil {
+			return nil, err
+		}
 	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading 'ca-file': %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in '%s'", cfg.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	p.client = &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+	return p, nil
 }
 
 // GetUsedNodes returns a map of cluster names to sorted lists of unique hostnames
 // that are currently in use by jobs that started before the given timestamp.
+// Failed requests are retried with exponential backoff, as long as the
+// attempts together do not exceed totalTimeout, since the retention of the
+// memory store waits for the answer. If all of them fail, the last
+// successful answer is returned if it is not older than maxStale.
 func (p *BackendNodeProvider) GetUsedNodes(ts int64) (map[string][]string, error) {
+	deadline := time.Now().Add(p.totalTimeout)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	var err error
+	delay := p.retryDelay
+	for attempt := 0; ; attempt++ {
+		var result map[string][]string
+		var retry bool
+		result, retry, err = p.fetchUsedNodes(ctx, ts)
+		if err == nil {
+			p.lock.Lock()
+			p.last, p.lastTime = result, time.Now()
+			p.lock.Unlock()
+			return result, nil
+		}
+		if !retry || attempt >= p.retries {
+			break
+		}
+		if time.Now().Add(delay).After(deadline) {
+			cclog.Warnf("node provider: %s, giving up after %s", err.Error(), p.totalTimeout)
+			break
+		}
+		cclog.Warnf("node provider: %s, retrying in %s", err.Error(), delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.last != nil && p.maxStale > 0 && time.Since(p.lastTime) <= p.maxStale {
+		cclog.Warnf("node provider: %s, using the answer from %s", err.Error(), p.lastTime.Format(time.RFC3339))
+		return p.last, nil
+	}
+	return nil, err
+}
+
+// fetchUsedNodes does a single request, which is aborted at the deadline of
+// ctx. retry reports whether a failed request may succeed when repeated.
+func (p *BackendNodeProvider) fetchUsedNodes(ctx context.Context, ts int64) (result map[string][]string, retry bool, err error) {
 	url := fmt.Sprintf("%s/api/jobs/used_nodes?ts=%d", p.backendURL, ts)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("querying used nodes from backend: %w", err)
+	}
+
+	token := p.token
+	if p.tokenFile != nil {
+		if token, err = p.tokenFile.get(); err != nil {
+			return nil, false, err
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json")
 
-	resp, err := p.client.Get(url)
+	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("querying used nodes from backend: %w", err)
+		return nil, true, fmt.Errorf("querying used nodes from backend: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("backend returned status %d", resp.StatusCode)
+		// Authentication and other client errors will not go away by
+		// retrying, unlike a cc-backend that is (re)starting.
+		retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return nil, retry, fmt.Errorf("backend returned status %d", resp.StatusCode)
 	}
 
-	var result map[string][]string
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("decoding used nodes response: %w", err)
+		return nil, true, fmt.Errorf("decoding used nodes response: %w", err)
 	}
 
-	return result, nil
+	return result, false, nil
+}
+
+// CompositeNodeProvider implements metricstore.NodeProvider by merging the
+// used nodes of several providers. If one of them fails, no nodes are
+// reported at all, as freeing the data of a node still in use cannot be
+// undone.
+type CompositeNodeProvider struct {
+	providers []metricstore.NodeProvider
+}
+
+// NewCompositeNodeProvider creates a CompositeNodeProvider merging providers.
+func NewCompositeNodeProvider(providers ...metricstore.NodeProvider) *CompositeNodeProvider {
+	return &CompositeNodeProvider{providers: providers}
+}
+
+// GetUsedNodes returns the union of the used nodes of all providers.
+func (p *CompositeNodeProvider) GetUsedNodes(ts int64) (map[string][]string, error) {
+	merged := map[string][]string{}
+	for i, provider := range p.providers {
+		nodes, err := provider.GetUsedNodes(ts)
+		if err != nil {
+			return nil, fmt.Errorf("node provider %d: %w", i, err)
+		}
+		for cluster, hosts := range nodes {
+			merged[cluster] = append(merged[cluster], hosts...)
+		}
+	}
+
+	for cluster, hosts := range merged {
+		slices.Sort(hosts)
+		merged[cluster] = slices.Compact(hosts)
+	}
+	return merged, nil
+}
+
+// NewNodeProvider creates the node provider selected by the `node-providers`
+// option, which includes the deprecated `backend-url`. It returns nil if none
+// is configured.
+func NewNodeProvider(cfgs []config.NodeProviderConfig) (metricstore.NodeProvider, error) {
+	providers := []metricstore.NodeProvider{}
+	for i, cfg := range cfgs {
+		switch cfg.Type {
+		case "backend":
+			if cfg.URL == "" {
+				return nil, fmt.Errorf("node-providers[%d]: 'url' is required", i)
+			}
+			p, err := NewBackendNodeProvider(cfg)
+			if err != nil {
+				return nil, fmt.Errorf("node-providers[%d]: %w", i, err)
+			}
+			providers = append(providers, p)
+		case "static":
+			if cfg.File == "" {
+				return nil, fmt.Errorf("node-providers[%d]: 'file' is required", i)
+			}
+			p, err := NewStaticNodeProvider(cfg.File)
+			if err != nil {
+				return nil, fmt.Errorf("node-providers[%d]: %w", i, err)
+			}
+			providers = append(providers, p)
+		case "slurm":
+			if cfg.File == "" {
+				return nil, fmt.Errorf("node-providers[%d]: 'file' is required", i)
+			}
+			providers = append(providers, NewSlurmNodeProvider(cfg.File, cfg.Cluster))
+		default:
+			return nil, fmt.Errorf("node-providers[%d]: unknown type '%s'", i, cfg.Type)
+		}
+	}
+
+	switch len(providers) {
+	case 0:
+		return nil, nil
+	case 1:
+		return providers[0], nil
+	default:
+		return NewCompositeNodeProvider(providers...), nil
+	}
 }
@@ -0,0 +1,316 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// This file implements the node providers that read the used nodes from a
+// file instead of asking cc-backend: a static list of hosts per cluster and a
+// JSON dump of the Slurm job list.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+)
+
+// watchedFile holds the parsed content of a file and parses it again when
+// its modification time or size changes. If the new content cannot be
+// parsed (e.g. because the file is just being written), the last good
+// content is kept.
+type watchedFile[T any] struct {
+	path  string
+	parse func([]byte) (T, error)
+
+	lock    sync.Mutex
+	modTime time.Time
+	size    int64
+	value   T
+	loaded  bool
+}
+
+func (wf *watchedFile[T]) get() (T, error) {
+	wf.lock.Lock()
+	defer wf.lock.Unlock()
+
+	fi, err := os.Stat(wf.path)
+	if err == nil && wf.loaded && fi.ModTime().Equal(wf.modTime) && fi.Size() == wf.size {
+		return wf.value, nil
+	}
+
+	var value T
+	if err == nil {
+		var raw []byte
+		if raw, err = os.ReadFile(wf.path); err == nil {
+			value, err = wf.parse(raw)
+		}
+	}
+	if err != nil {
+		if wf.loaded {
+			cclog.Warnf("node provider: keeping the previous content of '%s': %s", wf.path, err.Error())
+			return wf.value, nil
+		}
+		return value, fmt.Errorf("reading '%s': %w", wf.path, err)
+	}
+
+	wf.value, wf.modTime, wf.size, wf.loaded = value, fi.ModTime(), fi.Size(), true
+	return value, nil
+}
+
+// StaticNodeProvider implements metricstore.NodeProvider with a JSON file
+// mapping cluster names to lists of hosts, e.g. `{"fritz": ["f0101"]}`.
+// The listed hosts are always reported as used.
+type StaticNodeProvider struct {
+	file *watchedFile[map[string][]string]
+}
+
+// NewStaticNodeProvider creates a StaticNodeProvider for the file at path.
+// The file has to be readable already.
+func NewStaticNodeProvider(path string) (*StaticNodeProvider, error) {
+	p := &StaticNodeProvider{file: &watchedFile[map[string][]string]{
+		path: path,
+		parse: func(raw []byte) (map[string][]string, error) {
+			var nodes map[string][]string
+			dec := json.NewDecoder(bytes.NewReader(raw))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&nodes); err != nil {
+				return nil, err
+			}
+			for cluster, hosts := range nodes {
+				slices.Sort(hosts)
+				nodes[cluster] = slices.Compact(hosts)
+			}
+			return nodes, nil
+		},
+	}}
+	if _, err := p.file.get(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetUsedNodes returns the hosts of the file, regardless of ts.
+func (p *StaticNodeProvider) GetUsedNodes(ts int64) (map[string][]string, error) {
+	return p.file.get()
+}
+
+// slurmJob is a job of a Slurm dump reduced to what SlurmNodeProvider needs.
+type slurmJob struct {
+	cluster string
+	running bool
+	start   int64
+	nodes   []string
+}
+
+// slurmNumber is a number in the JSON output of Slurm. Since Slurm 23.02 it
+// is an object like `{"set": true, "infinite": false, "number": 42}`.
+type slurmNumber int64
+
+func (n *slurmNumber) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '{' {
+		var v struct {
+			Set    bool  `json:"set"`
+			Number int64 `json:"number"`
+		}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+		if !v.Set {
+			v.Number = 0
+		}
+		*n = slurmNumber(v.Number)
+		return nil
+	}
+	return json.Unmarshal(b, (*int64)(n))
+}
+
+// slurmStates is the job_state of a job, a string in older Slurm versions
+// and a list of strings in newer ones.
+type slurmStates []string
+
+func (s *slurmStates) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		var v string
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+		*s = slurmStates{v}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(s))
+}
+
+// slurmRunningStates are the job states for which the nodes are in use.
+var slurmRunningStates = []string{"RUNNING", "COMPLETING", "SUSPENDED"}
+
+// parseSlurmDump reads the output of `squeue --json` or
+// `scontrol show job --json`.
+func parseSlurmDump(raw []byte, defaultCluster string) ([]slurmJob, error) {
+	var dump struct {
+		Jobs []struct {
+			Cluster   string      `json:"cluster"`
+			JobState  slurmStates `json:"job_state"`
+			StartTime slurmNumber `json:"start_time"`
+			Nodes     string      `json:"nodes"`
+		} `json:"jobs"`
+	}
+	if err := json.Unmarshal(raw, &dump); err != nil {
+		return nil, err
+	}
+	if dump.Jobs == nil {
+		return nil, fmt.Errorf("no 'jobs' list found")
+	}
+
+	jobs := make([]slurmJob, 0, len(dump.Jobs))
+	for _, j := range dump.Jobs {
+		nodes, err := expandHostlist(j.Nodes)
+		if err != nil {
+			return nil, err
+		}
+		cluster := j.Cluster
+		if cluster == "" {
+			cluster = defaultCluster
+		}
+		jobs = append(jobs, slurmJob{
+			cluster: cluster,
+			running: slices.ContainsFunc(j.JobState, func(s string) bool { return slices.Contains(slurmRunningStates, s) }),
+			start:   int64(j.StartTime),
+			nodes:   nodes,
+		})
+	}
+	return jobs, nil
+}
+
+// expandHostlist expands a Slurm hostlist like `f[0101-0103,0110],g0001`.
+func expandHostlist(list string) ([]string, error) {
+	hosts := []string{}
+	depth, start := 0, 0
+	for i := 0; i <= len(list); i++ {
+		if i < len(list) {
+			switch list[i] {
+			case '[':
+				depth++
+				continue
+			case ']':
+				depth--
+				continue
+			case ',':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+
+		if depth != 0 {
+			return nil, fmt.Errorf("invalid hostlist '%s'", list)
+		}
+		if item := strings.TrimSpace(list[start:i]); item != "" {
+			expanded, err := expandHostlistItem(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hostlist '%s': %w", list, err)
+			}
+			hosts = append(hosts, expanded...)
+		}
+		start = i + 1
+	}
+	return hosts, nil
+}
+
+// expandHostlistItem expands the ranges of a single hostlist item, e.g.
+// `f[01-02]-ib[1,3]`.
+func expandHostlistItem(item string) ([]string, error) {
+	open := strings.IndexByte(item, '[')
+	if open < 0 {
+		return []string{item}, nil
+	}
+	end := strings.IndexByte(item[open:], ']')
+	if end < 0 {
+		return nil, fmt.Errorf("missing ']'")
+	}
+	end += open
+	prefix, ranges, rest := item[:open], item[open+1:end], item[end+1:]
+
+	suffixes, err := expandHostlistItem(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := []string{}
+	for _, r := range strings.Split(ranges, ",") {
+		lo, hi, isRange := strings.Cut(r, "-")
+		if !isRange {
+			hi = lo
+		}
+		from, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range '%s'", r)
+		}
+		to, err := strconv.Atoi(hi)
+		if err != nil || to < from {
+			return nil, fmt.Errorf("invalid range '%s'", r)
+		}
+		for n := from; n <= to; n++ {
+			// Zero padding is given by the width of the lower bound.
+			id := fmt.Sprintf("%0*d", len(lo), n)
+			for _, s := range suffixes {
+				hosts = append(hosts, prefix+id+s)
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// SlurmNodeProvider implements metricstore.NodeProvider with a JSON dump of
+// the Slurm job list (`squeue --json` or `scontrol show job --json`),
+// written periodically by e.g. a cron job. The file is read again whenever
+// it changes.
+type SlurmNodeProvider struct {
+	file *watchedFile[[]slurmJob]
+}
+
+// NewSlurmNodeProvider creates a SlurmNodeProvider for the dump at path.
+// Jobs without a cluster field are assigned to cluster. The dump does not
+// have to exist yet.
+func NewSlurmNodeProvider(path, cluster string) *SlurmNodeProvider {
+	p := &SlurmNodeProvider{file: &watchedFile[[]slurmJob]{
+		path: path,
+		parse: func(raw []byte) ([]slurmJob, error) {
+			return parseSlurmDump(raw, cluster)
+		},
+	}}
+	if _, err := p.file.get(); err != nil {
+		cclog.Warnf("node provider: %s", err.Error())
+	}
+	return p
+}
+
+// GetUsedNodes returns the nodes of the running jobs that started before ts.
+func (p *SlurmNodeProvider) GetUsedNodes(ts int64) (map[string][]string, error) {
+	jobs, err := p.file.get()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := map[string][]string{}
+	for _, j := range jobs {
+		if j.running && j.start < ts && j.cluster != "" {
+			nodes[j.cluster] = append(nodes[j.cluster], j.nodes...)
+		}
+	}
+	for cluster, hosts := range nodes {
+		slices.Sort(hosts)
+		nodes[cluster] = slices.Compact(hosts)
+	}
+	return nodes, nil
+}
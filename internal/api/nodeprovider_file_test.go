@@ -0,0 +1,146 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExpandHostlist(t *testing.T) {
+	tests := map[string][]string{
+		"":                          {},
+		"f0101":                     {"f0101"},
+		"f0101,g0001":               {"f0101", "g0001"},
+		" f0101 ,, g0001 ":          {"f0101", "g0001"},
+		"f[0101-0103,0110],g0001":   {"f0101", "f0102", "f0103", "f0110", "g0001"},
+		"f[8-11]":                   {"f8", "f9", "f10", "f11"},
+		"f[08-11]":                  {"f08", "f09", "f10", "f11"},
+		"f[01-02]-ib[1,3]":          {"f01-ib1", "f01-ib3", "f02-ib1", "f02-ib3"},
+		"rack[1]-n[1-2],login[0-0]": {"rack1-n1", "rack1-n2", "login0"},
+	}
+	for list, want := range tests {
+		got, err := expandHostlist(list)
+		if err != nil || !slices.Equal(got, want) {
+			t.Errorf("expandHostlist(%q) = %v, %v, want %v", list, got, err, want)
+		}
+	}
+
+	for _, list := range []string{"f[0101", "f0101]", "f[01-", "f[a-b]", "f[3-1]", "f[1,]", "f]0["} {
+		if got, err := expandHostlist(list); err == nil {
+			t.Errorf("expandHostlist(%q) = %v, want an error", list, got)
+		}
+	}
+}
+
+func TestParseSlurmDump(t *testing.T) {
+	// Newer Slurm versions use lists of states and number objects.
+	raw := []byte(`{"jobs": [
+		{"cluster": "fritz", "job_state": ["RUNNING"], "start_time": {"set": true, "number": 100}, "nodes": "f[0101-0102]"},
+		{"job_state": "PENDING", "start_time": {"set": false, "number": 0}, "nodes": ""},
+		{"job_state": "COMPLETING", "start_time": 200, "nodes": "a0001"}
+	]}`)
+	jobs, err := parseSlurmDump(raw, "alex")
+	if err != nil {
+		t.Fatalf("parseSlurmDump: %v", err)
+	}
+	want := []slurmJob{
+		{cluster: "fritz", running: true, start: 100, nodes: []string{"f0101", "f0102"}},
+		{cluster: "alex", running: false, start: 0, nodes: []string{}},
+		{cluster: "alex", running: true, start: 200, nodes: []string{"a0001"}},
+	}
+	equal := func(a, b slurmJob) bool {
+		return a.cluster == b.cluster && a.running == b.running && a.start == b.start && slices.Equal(a.nodes, b.nodes)
+	}
+	if !slices.EqualFunc(jobs, want, equal) {
+		t.Errorf("jobs %+v, want %+v", jobs, want)
+	}
+
+	for _, raw := range []string{`{}`, `{"jobs": [{"nodes": "f[01"}]}`, `[`} {
+		if _, err := parseSlurmDump([]byte(raw), ""); err == nil {
+			t.Errorf("parseSlurmDump(%s): no error", raw)
+		}
+	}
+}
+
+func TestSlurmNodeProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "squeue.json")
+	p := NewSlurmNodeProvider(path, "fritz")
+	if _, err := p.GetUsedNodes(1000); err == nil {
+		t.Error("no error without a dump")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"jobs": [
+		{"job_state": "RUNNING", "start_time": 100, "nodes": "f[0102,0101]"},
+		{"job_state": "RUNNING", "start_time": 100, "nodes": "f0101"},
+		{"job_state": "RUNNING", "start_time": 2000, "nodes": "f0103"},
+		{"job_state": "COMPLETED", "start_time": 100, "nodes": "f0104"}
+	]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"fritz": {"f0101", "f0102"}}
+	nodes, err := p.GetUsedNodes(1000)
+	if err != nil || !maps.EqualFunc(nodes, want, slices.Equal) {
+		t.Errorf("GetUsedNodes = %v, %v, want %v", nodes, err, want)
+	}
+
+	// A dump that cannot be parsed keeps the previous one.
+	if err := os.WriteFile(path, []byte(`{"jobs": [`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err = p.GetUsedNodes(1000)
+	if err != nil || !maps.EqualFunc(nodes, want, slices.Equal) {
+		t.Errorf("GetUsedNodes after a bad dump = %v, %v, want %v", nodes, err, want)
+	}
+}
+
+func TestStaticNodeProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.json")
+	if _, err := NewStaticNodeProvider(path); err == nil {
+		t.Error("no error for a missing file")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"fritz": ["f0102", "f0101", "f0102"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewStaticNodeProvider(path)
+	if err != nil {
+		t.Fatalf("NewStaticNodeProvider: %v", err)
+	}
+	want := map[string][]string{"fritz": {"f0101", "f0102"}}
+	if nodes, err := p.GetUsedNodes(0); err != nil || !maps.EqualFunc(nodes, want, slices.Equal) {
+		t.Errorf("GetUsedNodes = %v, %v, want %v", nodes, err, want)
+	}
+}
+
+// nodesFunc is a metricstore.NodeProvider for tests.
+type nodesFunc func(ts int64) (map[string][]string, error)
+
+func (f nodesFunc) GetUsedNodes(ts int64) (map[string][]string, error) { return f(ts) }
+
+func TestCompositeNodeProvider(t *testing.T) {
+	a := nodesFunc(func(int64) (map[string][]string, error) {
+		return map[string][]string{"fritz": {"f0102", "f0101"}}, nil
+	})
+	b := nodesFunc(func(int64) (map[string][]string, error) {
+		return map[string][]string{"fritz": {"f0101"}, "alex": {"a0001"}}, nil
+	})
+	fail := nodesFunc(func(int64) (map[string][]string, error) {
+		return nil, errors.New("unreachable")
+	})
+
+	want := map[string][]string{"fritz": {"f0101", "f0102"}, "alex": {"a0001"}}
+	if nodes, err := NewCompositeNodeProvider(a, b).GetUsedNodes(0); err != nil || !maps.EqualFunc(nodes, want, slices.Equal) {
+		t.Errorf("GetUsedNodes = %v, %v, want %v", nodes, err, want)
+	}
+	if nodes, err := NewCompositeNodeProvider(a, fail).GetUsedNodes(0); err == nil || nodes != nil {
+		t.Errorf("GetUsedNodes with a failing provider = %v, %v", nodes, err)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
+)
+
+// backendServer answers /api/jobs/used_nodes with the status codes of
+// statuses, one per request, and with a node list once they are used up.
+func backendServer(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	requests := &atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		if r.URL.Path != "/api/jobs/used_nodes" || r.URL.Query().Get("ts") != "1000" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization header %q", got)
+		}
+		if n <= len(statuses) {
+			rw.WriteHeader(statuses[n-1])
+			return
+		}
+		rw.Write([]byte(`{"fritz": ["f0101"]}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests
+}
+
+func TestBackendNodeProviderRetries(t *testing.T) {
+	retries := 2
+	srv, requests := backendServer(t, http.StatusServiceUnavailable, http.StatusBadGateway)
+	p, err := NewBackendNodeProvider(config.NodeProviderConfig{
+		Type: "backend", URL: srv.URL + "/", Token: "secret", Retries: &retries, RetryDelay: "1ms",
+	})
+	if err != nil {
+		t.Fatalf("NewBackendNodeProvider: %v", err)
+	}
+
+	nodes, err := p.GetUsedNodes(1000)
+	if err != nil || !slices.Equal(nodes["fritz"], []string{"f0101"}) || requests.Load() != 3 {
+		t.Errorf("GetUsedNodes = %v, %v after %d requests", nodes, err, requests.Load())
+	}
+
+	// Client errors are not retried.
+	srv, requests = backendServer(t, http.StatusUnauthorized)
+	p.backendURL = srv.URL
+	if _, err := p.GetUsedNodes(1000); err == nil || requests.Load() != 1 {
+		t.Errorf("GetUsedNodes = %v after %d requests", err, requests.Load())
+	}
+}
+
+func TestBackendNodeProviderTotalTimeout(t *testing.T) {
+	retries := 10
+	srv, requests := backendServer(t, slices.Repeat([]int{http.StatusInternalServerError}, 20)...)
+	p, err := NewBackendNodeProvider(config.NodeProviderConfig{
+		Type: "backend", URL: srv.URL, Token: "secret", Retries: &retries, RetryDelay: "20ms", TotalTimeout: "100ms",
+	})
+	if err != nil {
+		t.Fatalf("NewBackendNodeProvider: %v", err)
+	}
+
+	// The delays are 20, 40 and 80ms, the last one would exceed the total
+	// timeout. On a slow machine, the second one may already.
+	start := time.Now()
+	if _, err := p.GetUsedNodes(1000); err == nil {
+		t.Error("GetUsedNodes: no error")
+	}
+	if d, n := time.Since(start), requests.Load(); d > time.Second || n < 2 || n > 3 {
+		t.Errorf("GetUsedNodes gave up after %s and %d requests", d, n)
+	}
+}
+
+func TestBackendNodeProviderSlowBackend(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	p, err := NewBackendNodeProvider(config.NodeProviderConfig{
+		Type: "backend", URL: srv.URL, Timeout: "10s", RetryDelay: "1ms", TotalTimeout: "50ms",
+	})
+	if err != nil {
+		t.Fatalf("NewBackendNodeProvider: %v", err)
+	}
+
+	start := time.Now()
+	if _, err := p.GetUsedNodes(1000); err == nil {
+		t.Error("GetUsedNodes: no error")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("GetUsedNodes took %s", d)
+	}
+}
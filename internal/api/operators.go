@@ -0,0 +1,128 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+)
+
+// seriesOperators combine the values of all series at one point in time.
+// NaN values are skipped by the caller, values is never empty.
+var seriesOperators = map[string]func(values []float64) float64{
+	"sum": func(values []float64) float64 {
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	},
+	"avg": func(values []float64) float64 {
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	},
+	"min": func(values []float64) float64 {
+		m := values[0]
+		for _, v := range values[1:] {
+			m = math.Min(m, v)
+		}
+		return m
+	},
+	"max": func(values []float64) float64 {
+		m := values[0]
+		for _, v := range values[1:] {
+			m = math.Max(m, v)
+		}
+		return m
+	},
+	"stddev": func(values []float64) float64 {
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		mean, sq := sum/float64(len(values)), 0.0
+		for _, v := range values {
+			sq += (v - mean) * (v - mean)
+		}
+		return math.Sqrt(sq / float64(len(values)))
+	},
+}
+
+func checkOperator(op string) error {
+	if _, ok := seriesOperators[op]; op != "" && !ok {
+		return fmt.Errorf("unknown operator '%s'", op)
+	}
+	return nil
+}
+
+// combineSeries merges the series read for the selectors of a query into a
+// single series by applying op to the values at each timestamp. The series
+// are placed on the grid of the earliest one: a point counts for the step of
+// the grid it falls into, so series sampled at different phases are combined
+// step by step. The result covers the union of their time ranges, points
+// without any value are NaN. If one of the series carries an error, it is
+// returned instead. Series of different resolutions are not combined.
+func combineSeries(op string, series []APIMetricData) APIMetricData {
+	if len(series) == 0 {
+		return APIMetricData{}
+	}
+
+	res := APIMetricData{
+		From:       series[0].From,
+		To:         series[0].To,
+		Resolution: series[0].Resolution,
+		Downsample: series[0].Downsample,
+		Unit:       series[0].Unit,
+	}
+	for _, s := range series {
+		if s.Error != nil {
+			return s
+		}
+		res.From, res.To = min(res.From, s.From), max(res.To, s.To)
+	}
+	for _, s := range series {
+		if s.Resolution != res.Resolution {
+			msg := fmt.Sprintf("cannot combine series with resolutions %d and %d", res.Resolution, s.Resolution)
+			return APIMetricData{Error: &msg}
+		}
+	}
+	if res.Resolution <= 0 {
+		return res
+	}
+
+	offsets := make([]int, len(series))
+	n := 0
+	for k, s := range series {
+		offsets[k] = int((s.From - res.From) / res.Resolution)
+		n = max(n, offsets[k]+len(s.Data))
+	}
+
+	fn := seriesOperators[op]
+	res.Data = make(schema.FloatArray, n)
+	values := make([]float64, 0, len(series))
+	for i := range res.Data {
+		values = values[:0]
+		for k, s := range series {
+			j := i - offsets[k]
+			if j < 0 || j >= len(s.Data) || s.Data[j].IsNaN() {
+				continue
+			}
+			values = append(values, float64(s.Data[j]))
+		}
+
+		if len(values) == 0 {
+			res.Data[i] = schema.NaN
+		} else {
+			res.Data[i] = schema.Float(fn(values))
+		}
+	}
+	return res
+}
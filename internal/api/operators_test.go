@@ -0,0 +1,84 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+)
+
+func TestSeriesOperators(t *testing.T) {
+	values := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+	want := map[string]float64{"sum": 40, "avg": 5, "min": 2, "max": 9, "stddev": 2}
+	for op, w := range want {
+		if got := seriesOperators[op](values); math.Abs(got-w) > 1e-9 {
+			t.Errorf("%s = %v, want %v", op, got, w)
+		}
+	}
+
+	if err := checkOperator(""); err != nil {
+		t.Errorf("checkOperator(\"\"): %v", err)
+	}
+	if err := checkOperator("median"); err == nil {
+		t.Error("checkOperator(\"median\"): no error")
+	}
+}
+
+func TestCombineSeries(t *testing.T) {
+	nan := schema.NaN
+	series := []APIMetricData{
+		{From: 100, To: 220, Resolution: 60, Unit: "F/s", Data: schema.FloatArray{1, 2, nan}},
+		{From: 160, To: 280, Resolution: 60, Data: schema.FloatArray{10, nan, 30}},
+	}
+
+	res := combineSeries("sum", series)
+	if res.From != 100 || res.To != 280 || res.Resolution != 60 || res.Unit != "F/s" {
+		t.Errorf("unexpected range %d-%d/%d, unit %v", res.From, res.To, res.Resolution, res.Unit)
+	}
+	// Timestamps 100, 160, 220 and 280; at 220 both values are NaN.
+	want := []float64{1, 12, math.NaN(), 30}
+	if len(res.Data) != len(want) {
+		t.Fatalf("data %v, want %v", res.Data, want)
+	}
+	for i, w := range want {
+		got := res.Data[i]
+		if math.IsNaN(w) != got.IsNaN() || (!got.IsNaN() && float64(got) != w) {
+			t.Errorf("data[%d] = %v, want %v", i, got, w)
+		}
+	}
+
+	if res := combineSeries("max", series); res.Data[1] != 10 {
+		t.Errorf("max at 160 = %v, want 10", res.Data[1])
+	}
+
+	// Points are placed in the step of the grid they fall into.
+	shifted := []APIMetricData{
+		{From: 100, To: 220, Resolution: 60, Downsample: "avg", Data: schema.FloatArray{1, 2}},
+		{From: 130, To: 250, Resolution: 60, Downsample: "avg", Data: schema.FloatArray{10, 20}},
+		{From: 210, To: 270, Resolution: 60, Downsample: "avg", Data: schema.FloatArray{100}},
+	}
+	res = combineSeries("sum", shifted)
+	if len(res.Data) != 2 || res.Data[0] != 11 || res.Data[1] != 122 || res.Downsample != "avg" {
+		t.Errorf("combining shifted series: %v, downsample %q", res.Data, res.Downsample)
+	}
+
+	mixed := append(series, APIMetricData{From: 100, To: 220, Resolution: 30, Data: schema.FloatArray{1, 2, 3, 4}})
+	if res := combineSeries("sum", mixed); res.Error == nil {
+		t.Errorf("combining resolutions 60 and 30: %v", res.Data)
+	}
+
+	msg := "metric not found"
+	failed := append(series, APIMetricData{Error: &msg})
+	if res := combineSeries("sum", failed); res.Error == nil || *res.Error != msg {
+		t.Errorf("error %v, want %q", res.Error, msg)
+	}
+
+	if res := combineSeries("sum", nil); res.Data != nil {
+		t.Errorf("combining no series: %v", res.Data)
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	"github.com/ClusterCockpit/cc-line-protocol/v2/lineprotocol"
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// maxPromDecodedSize bounds the size of a remote-write request after snappy
+// decompression. The decoded length is read from the payload header, which
+// is checked before any memory is allocated.
+const maxPromDecodedSize = 32 << 20
+
+// maxPromBodySize bounds the size of the compressed body of a remote-write
+// request: the largest snappy encoding of maxPromDecodedSize bytes. It
+// applies whether or not `max-body-size` is set.
+var maxPromBodySize = int64(snappy.MaxEncodedLen(maxPromDecodedSize))
+
+// promTags lists the hierarchy tags understood by metricstore.DecodeLine in
+// the lexical order required by lineprotocol.Encoder.AddTag.
+var promTags = []string{"cluster", "hostname", "stype", "stype-id", "type", "type-id"}
+
+// promLabel returns the Prometheus label that holds the value of the
+// hierarchy tag. Prometheus label names may not contain '-', so by default
+// `type-id` is read from `type_id` and so on.
+func promLabel(tag string) string {
+	if l, ok := config.Keys.PromWrite.Labels[tag]; ok {
+		return l
+	}
+	return strings.ReplaceAll(tag, "-", "_")
+}
+
+// promMetricName maps a Prometheus metric name onto a metric store name.
+func promMetricName(name string) string {
+	if n, ok := config.Keys.PromWrite.MetricNames[name]; ok {
+		return n
+	}
+	return name
+}
+
+type promLabelPair struct {
+	name, value string
+}
+
+type promSample struct {
+	value     float64
+	timestamp int64 // Milliseconds since epoch
+}
+
+// decodePromWriteRequest decodes a protobuf encoded prometheus.WriteRequest
+// and calls fn for every contained time series. Only labels and float
+// samples are decoded, exemplars, histograms and metadata are skipped.
+func decodePromWriteRequest(b []byte, fn func(labels []promLabelPair, samples []promSample) error) error {
+	var labels []promLabelPair
+	var samples []promSample
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		// WriteRequest.timeseries = 1
+		if num != 1 || typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		ts, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		labels, samples = labels[:0], samples[:0]
+		for len(ts) > 0 {
+			num, typ, n := protowire.ConsumeTag(ts)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			ts = ts[n:]
+
+			switch {
+			// TimeSeries.labels = 1
+			case num == 1 && typ == protowire.BytesType:
+				v, n := protowire.ConsumeBytes(ts)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				ts = ts[n:]
+				l, err := decodePromLabel(v)
+				if err != nil {
+					return err
+				}
+				labels = append(labels, l)
+			// TimeSeries.samples = 2
+			case num == 2 && typ == protowire.BytesType:
+				v, n := protowire.ConsumeBytes(ts)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				ts = ts[n:]
+				s, err := decodePromSample(v)
+				if err != nil {
+					return err
+				}
+				samples = append(samples, s)
+			default:
+				if n = protowire.ConsumeFieldValue(num, typ, ts); n < 0 {
+					return protowire.ParseError(n)
+				}
+				ts = ts[n:]
+			}
+		}
+
+		if err := fn(labels, samples); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func decodePromLabel(b []byte) (promLabelPair, error) {
+	l := promLabelPair{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return l, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ == protowire.BytesType && (num == 1 || num == 2) {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return l, protowire.ParseError(n)
+			}
+			b = b[n:]
+			if num == 1 {
+				l.name = string(v)
+			} else {
+				l.value = string(v)
+			}
+			continue
+		}
+
+		if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+			return l, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return l, nil
+}
+
+func decodePromSample(b []byte) (promSample, error) {
+	s := promSample{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return s, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		// Sample.value = 1
+		case num == 1 && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			b = b[n:]
+			s.value = math.Float64frombits(v)
+		// Sample.timestamp = 2
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			b = b[n:]
+			s.timestamp = int64(v)
+		default:
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return s, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return s, nil
+}
+
+// promToLineProtocol converts a decoded remote-write request into InfluxDB
+// line-protocol, so it can be fed into the memory store by
+// metricstore.DecodeLine exactly like data received on /api/write/. Series
+// without a cluster label belong to clusterDefault. It returns the encoded
+// lines and the number of samples that were dropped.
+func promToLineProtocol(body []byte, ms *metricstore.MemoryStore, clusterDefault string) ([]byte, int, error) {
+	enc := lineprotocol.Encoder{}
+	enc.SetPrecision(lineprotocol.Second)
+	dropped := 0
+
+	tagLabels := make([]string, len(promTags))
+	for i, tag := range promTags {
+		tagLabels[i] = promLabel(tag)
+	}
+	tagValues := make([]string, len(promTags))
+
+	err := decodePromWriteRequest(body, func(labels []promLabelPair, samples []promSample) error {
+		name := ""
+		clear(tagValues)
+		for _, l := range labels {
+			if l.name == "__name__" {
+				name = promMetricName(l.value)
+				continue
+			}
+			for i, tl := range tagLabels {
+				if l.name == tl {
+					tagValues[i] = l.value
+				}
+			}
+		}
+
+		if _, ok := metricConfig(ms, name); !ok {
+			// tagValues[0] and [1] are the cluster and the hostname.
+			cluster := tagValues[0]
+			if cluster == "" {
+				cluster = clusterDefault
+			}
+			register, err := unknownMetric("prom-write", name, cluster, tagValues[1], len(samples))
+			if err != nil {
+				return err
+			}
+			if !register {
+				cclog.Debugf("/api/prom/write: dropping %d samples of unknown metric '%s'", len(samples), name)
+				dropped += len(samples)
+				return nil
+			}
+		}
+
+		measurement := config.MetricSlot(name)
+		// tagValues[4] and [2] are the type and the stype.
+		scope := lineScope(tagValues[4], tagValues[2])
+		for _, s := range samples {
+			// Skip staleness markers and other values line-protocol cannot
+			// represent.
+			if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+				dropped++
+				continue
+			}
+			if err := checkSample("prom-write", name, scope, s.value); err != nil {
+				cclog.Debugf("/api/prom/write: dropping sample of host '%s': %s", tagValues[1], err.Error())
+				dropped++
+				continue
+			}
+
+			enc.StartLine(measurement)
+			for i, tag := range promTags {
+				if tagValues[i] != "" {
+					enc.AddTag(tag, tagValues[i])
+				}
+			}
+			v, _ := lineprotocol.FloatValue(s.value)
+			enc.AddField("value", v)
+			enc.EndLine(time.UnixMilli(s.timestamp))
+
+			if err := enc.Err(); err != nil {
+				cclog.Debugf("/api/prom/write: dropping sample of metric '%s': %s", name, err.Error())
+				enc.ClearErr()
+				dropped++
+			}
+		}
+		return nil
+	})
+
+	return enc.Bytes(), dropped, err
+}
+
+// handlePromWrite godoc
+// @summary Receive metrics via Prometheus remote-write
+// @tags write
+// @description Write data to the in-memory store using the Prometheus
+// remote-write protocol (snappy compressed protobuf). The labels of each
+// series are mapped onto the metric store hierarchy as configured in the
+// `prometheus-write` section of the main config.
+// @accept      application/x-protobuf
+// @produce     json
+// @param       cluster        query string false "If the series do not have a cluster label, use this value instead."
+// @success     204            "No Content"
+// @failure     400            {object} ErrorResponse       "Bad Request"
+// @failure     401            {object} ErrorResponse       "Unauthorized"
+// @failure     403            {object} ErrorResponse       "Forbidden"
+// @failure     413            {object} ErrorResponse       "Request Entity Too Large"
+// @failure     500            {object} ErrorResponse       "Internal Server Error"
+// @security    ApiKeyAuth
+// @router      /prom/write/ [post]
+func handlePromWrite(rw http.ResponseWriter, r *http.Request) {
+	cluster := queryParam(r.URL.RawQuery, "cluster")
+
+	limit := maxPromBodySize
+	if m := config.Keys.QueryLimits.MaxBodySize; m > 0 && m < limit {
+		limit = m
+	}
+	compressed, err := io.ReadAll(http.MaxBytesReader(rw, r.Body, limit))
+	if err != nil {
+		handleError(fmt.Errorf("reading request body: %w", err), bodyErrorStatus(err), rw)
+		return
+	}
+
+	n, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		writeDecodeErrors.inc("prom-write")
+		handleError(fmt.Errorf("decompressing snappy payload: %w", err), http.StatusBadRequest, rw)
+		return
+	}
+	if n > maxPromDecodedSize {
+		writeDecodeErrors.inc("prom-write")
+		handleError(fmt.Errorf("decompressed payload of %d bytes exceeds the limit of %d bytes", n, maxPromDecodedSize),
+			http.StatusRequestEntityTooLarge, rw)
+		return
+	}
+
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		writeDecodeErrors.inc("prom-write")
+		handleError(fmt.Errorf("decompressing snappy payload: %w", err), http.StatusBadRequest, rw)
+		return
+	}
+
+	ms := metricstore.GetMemoryStore()
+	lines, dropped, err := promToLineProtocol(body, ms, cluster)
+	if err != nil {
+		writeDecodeErrors.inc("prom-write")
+		handleError(fmt.Errorf("decoding remote-write request: %w", err), http.StatusBadRequest, rw)
+		return
+	}
+	if dropped > 0 {
+		cclog.Debugf("/api/prom/write: %d samples dropped in total", dropped)
+	}
+
+	if len(lines) > 0 {
+		writeLines.add(uint64(bytes.Count(lines, []byte{'\n'})), "prom-write")
+		dec := lineprotocol.NewDecoderWithBytes(lines)
+		if err := metricstore.DecodeLine(dec, ms, cluster); err != nil {
+			writeDecodeErrors.inc("prom-write")
+			cclog.Errorf("/api/prom/write error: %s", err.Error())
+			handleError(fmt.Errorf("writing converted samples: %w", err), http.StatusBadRequest, rw)
+			return
+		}
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
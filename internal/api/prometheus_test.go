@@ -0,0 +1,181 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type promSeries struct {
+	labels  []promLabelPair
+	samples []promSample
+}
+
+// encodePromWriteRequest encodes series as prometheus.WriteRequest. An
+// unknown field is added to every message to check that it is skipped.
+func encodePromWriteRequest(series []promSeries) []byte {
+	var b []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+		for _, smp := range s.samples {
+			var sb []byte
+			sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(smp.value))
+			sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, uint64(smp.timestamp))
+			sb = protowire.AppendTag(sb, 9, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, 42)
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, sb)
+		}
+		// TimeSeries.exemplars = 3
+		ts = protowire.AppendTag(ts, 3, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, []byte{0x08, 0x01})
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+	// WriteRequest.metadata = 3
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendBytes(b, []byte("ignored"))
+	return b
+}
+
+func TestDecodePromWriteRequest(t *testing.T) {
+	want := []promSeries{
+		{
+			labels:  []promLabelPair{{"__name__", "cpu_load"}, {"cluster", "fritz"}, {"hostname", "f0101"}},
+			samples: []promSample{{1.5, 1700000000000}, {2.5, 1700000060000}},
+		},
+		{
+			labels:  []promLabelPair{{"__name__", "mem_used"}, {"hostname", "f0102"}},
+			samples: []promSample{{-3, 1700000000123}},
+		},
+	}
+
+	got := []promSeries{}
+	err := decodePromWriteRequest(encodePromWriteRequest(want), func(labels []promLabelPair, samples []promSample) error {
+		// The slices are reused for the next series.
+		got = append(got, promSeries{slices.Clone(labels), slices.Clone(samples)})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("decodePromWriteRequest: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d series, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !slices.Equal(got[i].labels, want[i].labels) {
+			t.Errorf("series %d: labels %v, want %v", i, got[i].labels, want[i].labels)
+		}
+		if !slices.Equal(got[i].samples, want[i].samples) {
+			t.Errorf("series %d: samples %v, want %v", i, got[i].samples, want[i].samples)
+		}
+	}
+}
+
+func TestDecodePromWriteRequestTruncated(t *testing.T) {
+	b := encodePromWriteRequest([]promSeries{{
+		labels:  []promLabelPair{{"__name__", "cpu_load"}},
+		samples: []promSample{{1, 1700000000000}},
+	}})
+	for _, n := range []int{1, 5, len(b) / 2} {
+		err := decodePromWriteRequest(b[:n], func([]promLabelPair, []promSample) error { return nil })
+		if err == nil {
+			t.Errorf("decoding %d of %d bytes: no error", n, len(b))
+		}
+	}
+}
+
+func TestPromToLineProtocol(t *testing.T) {
+	ms := &metricstore.MemoryStore{Metrics: map[string]metricstore.MetricConfig{
+		"cpu_load": {Frequency: 60},
+	}}
+	body := encodePromWriteRequest([]promSeries{
+		{
+			labels: []promLabelPair{{"__name__", "cpu_load"}, {"hostname", "f0101"}, {"type", "socket"}, {"type_id", "1"}},
+			samples: []promSample{
+				{1.5, 1700000000999},
+				{math.NaN(), 1700000060000},
+				{math.Inf(1), 1700000120000},
+			},
+		},
+		{
+			labels:  []promLabelPair{{"__name__", "unknown"}, {"hostname", "f0101"}},
+			samples: []promSample{{1, 1700000000000}, {2, 1700000060000}},
+		},
+	})
+
+	lines, dropped, err := promToLineProtocol(body, ms, "fritz")
+	if err != nil {
+		t.Fatalf("promToLineProtocol: %v", err)
+	}
+	// The cluster comes from the query parameter when DecodeLine decodes the
+	// lines, so it is not added here.
+	want := "cpu_load,hostname=f0101,type=socket,type-id=1 value=1.5 1700000000\n"
+	if string(lines) != want {
+		t.Errorf("lines %q, want %q", lines, want)
+	}
+	if dropped != 4 {
+		t.Errorf("dropped %d samples, want 4", dropped)
+	}
+}
+
+// zeroReader is an endless stream of zero bytes.
+type zeroReader struct{ n int64 }
+
+func (z *zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	z.n += int64(len(p))
+	return len(p), nil
+}
+
+func TestHandlePromWriteBodyTooLarge(t *testing.T) {
+	body := &zeroReader{}
+	req := httptest.NewRequest(http.MethodPost, "/api/prom/write/", body)
+	rw := httptest.NewRecorder()
+	handlePromWrite(rw, req)
+
+	if rw.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want %d", rw.Code, http.StatusRequestEntityTooLarge)
+	}
+	if body.n > maxPromBodySize+1<<20 {
+		t.Errorf("read %d bytes of the body, the limit is %d", body.n, maxPromBodySize)
+	}
+}
+
+func TestHandlePromWriteTooLarge(t *testing.T) {
+	// A snappy block only holding the varint encoded length of 1 GiB.
+	body := protowire.AppendVarint(nil, 1<<30)
+	req := httptest.NewRequest(http.MethodPost, "/api/prom/write/", bytes.NewReader(body))
+	rw := httptest.NewRecorder()
+	handlePromWrite(rw, req)
+
+	if rw.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want %d", rw.Code, http.StatusRequestEntityTooLarge)
+	}
+	if !strings.Contains(rw.Body.String(), "exceeds the limit") {
+		t.Errorf("unexpected error message %q", rw.Body.String())
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// queryParamList returns the values of a repeated or comma-separated query
+// parameter.
+func queryParamList(q url.Values, key string) []string {
+	res := []string{}
+	for _, v := range q[key] {
+		for _, s := range strings.Split(v, ",") {
+			if s != "" {
+				res = append(res, s)
+			}
+		}
+	}
+	return res
+}
+
+// parseQueryParams fills req from the URL form of /api/query, e.g.
+// `?cluster=fritz&host=f0101&metric=flops_any&from=1700000000&to=1700003600`.
+// One query is built per combination of `host` and `metric`.
+func parseQueryParams(q url.Values, req *APIQueryRequest) error {
+	req.Cluster = q.Get("cluster")
+	if req.Cluster == "" {
+		return fmt.Errorf("'cluster' is a required query parameter")
+	}
+
+	times := []struct {
+		key string
+		dst *int64
+	}{{"from", &req.From}, {"to", &req.To}}
+	for _, p := range times {
+		raw := q.Get(p.key)
+		if raw == "" {
+			return fmt.Errorf("'%s' is a required query parameter", p.key)
+		}
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid value for '%s': %#v", p.key, raw)
+		}
+		*p.dst = v
+	}
+
+	bools := []struct {
+		key string
+		dst *bool
+	}{{"with-stats", &req.WithStats}, {"with-data", &req.WithData}, {"with-padding", &req.WithPadding}}
+	for _, p := range bools {
+		if raw := q.Get(p.key); raw != "" {
+			v, err := strconv.ParseBool(raw)
+			if err != nil {
+				return fmt.Errorf("invalid value for '%s': %#v", p.key, raw)
+			}
+			*p.dst = v
+		}
+	}
+	req.Stats = queryParamList(q, "stats")
+
+	template := APIQuery{
+		TypeIds:    queryParamList(q, "type-ids"),
+		Downsample: q.Get("downsample"),
+		Operator:   q.Get("operator"),
+		TargetUnit: q.Get("target-unit"),
+	}
+	if typ := q.Get("type"); typ != "" {
+		template.Type = &typ
+	}
+	if raw := q.Get("resolution"); raw != "" {
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid value for 'resolution': %#v", raw)
+		}
+		template.Resolution = v
+	}
+	if raw := q.Get("aggreg"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid value for 'aggreg': %#v", raw)
+		}
+		template.Aggregate = v
+	}
+
+	hosts := queryParamList(q, "host")
+	if len(hosts) == 0 {
+		return fmt.Errorf("'host' is a required query parameter")
+	}
+	for _, metric := range queryParamList(q, "metric") {
+		if template.Operator != "" {
+			// Operators combine all hosts into a single series.
+			query := template
+			query.Metric, query.Hostname, query.Hosts = metric, hosts[0], hosts[1:]
+			req.Queries = append(req.Queries, query)
+			continue
+		}
+		for _, host := range hosts {
+			query := template
+			query.Metric, query.Hostname = metric, host
+			req.Queries = append(req.Queries, query)
+		}
+	}
+	return nil
+}
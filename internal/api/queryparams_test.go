@@ -0,0 +1,101 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestQueryParamList(t *testing.T) {
+	q, _ := url.ParseQuery("host=a,b&host=c&host=&metric=x,,y")
+	if got := queryParamList(q, "host"); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("host: %v", got)
+	}
+	if got := queryParamList(q, "metric"); !slices.Equal(got, []string{"x", "y"}) {
+		t.Errorf("metric: %v", got)
+	}
+	if got := queryParamList(q, "type"); got == nil || len(got) != 0 {
+		t.Errorf("missing key: %#v", got)
+	}
+}
+
+func TestParseQueryParams(t *testing.T) {
+	q, _ := url.ParseQuery("cluster=fritz&from=100&to=200&host=f0101,f0102&metric=cpu_load&metric=flops_any" +
+		"&type=socket&type-ids=0,1&resolution=120&aggreg=true&with-stats=false&with-data=true&stats=p90,median" +
+		"&downsample=max&target-unit=MF/s")
+	req := &APIQueryRequest{}
+	if err := parseQueryParams(q, req); err != nil {
+		t.Fatalf("parseQueryParams: %v", err)
+	}
+
+	if req.Cluster != "fritz" || req.From != 100 || req.To != 200 {
+		t.Errorf("cluster %q, from %d, to %d", req.Cluster, req.From, req.To)
+	}
+	if req.WithStats || !req.WithData || req.WithPadding {
+		t.Errorf("with-stats %v, with-data %v, with-padding %v", req.WithStats, req.WithData, req.WithPadding)
+	}
+	if !slices.Equal(req.Stats, []string{"p90", "median"}) {
+		t.Errorf("stats %v", req.Stats)
+	}
+
+	// One query per metric and host, the metric varying slowest.
+	want := [][2]string{{"cpu_load", "f0101"}, {"cpu_load", "f0102"}, {"flops_any", "f0101"}, {"flops_any", "f0102"}}
+	if len(req.Queries) != len(want) {
+		t.Fatalf("%d queries, want %d", len(req.Queries), len(want))
+	}
+	for i, w := range want {
+		query := req.Queries[i]
+		if query.Metric != w[0] || query.Hostname != w[1] {
+			t.Errorf("query %d: %s on %s, want %s on %s", i, query.Metric, query.Hostname, w[0], w[1])
+		}
+		if query.Type == nil || *query.Type != "socket" || !slices.Equal(query.TypeIds, []string{"0", "1"}) ||
+			query.Resolution != 120 || !query.Aggregate || query.Downsample != "max" || query.TargetUnit != "MF/s" {
+			t.Errorf("query %d: %+v", i, query)
+		}
+	}
+}
+
+func TestParseQueryParamsOperator(t *testing.T) {
+	q, _ := url.ParseQuery("cluster=fritz&from=100&to=200&host=f0101&host=f0102,f0103&metric=cpu_load&operator=sum")
+	req := &APIQueryRequest{}
+	if err := parseQueryParams(q, req); err != nil {
+		t.Fatalf("parseQueryParams: %v", err)
+	}
+
+	// All hosts are combined into a single query.
+	if len(req.Queries) != 1 {
+		t.Fatalf("%d queries, want 1", len(req.Queries))
+	}
+	query := req.Queries[0]
+	if query.Hostname != "f0101" || !slices.Equal(query.Hosts, []string{"f0102", "f0103"}) || query.Operator != "sum" {
+		t.Errorf("query %+v", query)
+	}
+}
+
+func TestParseQueryParamsErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		err   string
+	}{
+		{"from=1&to=2&host=a&metric=m", "'cluster' is a required query parameter"},
+		{"cluster=c&to=2&host=a&metric=m", "'from' is a required query parameter"},
+		{"cluster=c&from=1&host=a&metric=m", "'to' is a required query parameter"},
+		{"cluster=c&from=x&to=2&host=a&metric=m", `invalid value for 'from': "x"`},
+		{"cluster=c&from=1&to=2&metric=m", "'host' is a required query parameter"},
+		{"cluster=c&from=1&to=2&host=a&metric=m&resolution=-60", `invalid value for 'resolution': "-60"`},
+		{"cluster=c&from=1&to=2&host=a&metric=m&aggreg=maybe", `invalid value for 'aggreg': "maybe"`},
+		{"cluster=c&from=1&to=2&host=a&metric=m&with-data=2", `invalid value for 'with-data': "2"`},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		err := parseQueryParams(q, &APIQueryRequest{})
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: error %v, want %q", tt.query, err, tt.err)
+		}
+	}
+}
@@ -14,37 +14,59 @@ import (
 	"github.com/ClusterCockpit/cc-metric-store/internal/config"
 )
 
+// route describes an endpoint of the REST API. The endpoint name labels the
+// self-monitoring metrics, the policy selects the required roles (see
+// routeRoles).
+type route struct {
+	method, path, endpoint, policy string
+	handler                        http.HandlerFunc
+}
+
+var routes = []route{
+	{"POST", "/api/free", "free", "free", freeMetrics},
+	{"POST", "/api/write", "write", "write", writeMetrics},
+	{"GET", "/api/query", "query", "query", handleQuery},
+	{"POST", "/api/query", "query", "query", handleQuery},
+	{"GET", "/api/query/schema", "query", "query", handleQuerySchema},
+	{"GET", "/api/debug", "debug", "debug", debugMetrics},
+	{"GET", "/api/unknown-metrics", "unknown-metrics", "query", handleUnknownMetrics},
+	{"GET", "/api/clusters", "catalog", "query", handleListClusters},
+	{"GET", "/api/clusters/{cluster}/hosts", "catalog", "query", handleListHosts},
+	{"GET", "/api/clusters/{cluster}/hosts/{host}", "catalog", "query", handleHostTopology},
+	{"GET", "/api/metrics", "catalog", "query", handleListMetrics},
+	{"GET", "/api/healthcheck", "healthcheck", "healthcheck", metricsHealth},
+	{"POST", "/api/prom/write", "prom-write", "write", handlePromWrite},
+	{"POST", "/api/reload", "reload", "reload", reloadMetrics},
+	{"GET", "/api/grafana", "grafana", "query", grafanaHealth},
+	{"POST", "/api/grafana/search", "grafana", "query", grafanaSearch},
+	{"POST", "/api/grafana/query", "grafana", "query", grafanaQuery},
+	{"POST", "/api/grafana/annotations", "grafana", "query", grafanaAnnotations},
+}
+
 func MountRoutes(r *http.ServeMux) {
+	var publicKey ed25519.PublicKey
 	if len(config.Keys.JwtPublicKey) > 0 {
 		buf, err := base64.StdEncoding.DecodeString(config.Keys.JwtPublicKey)
 		if err != nil {
 			log.Fatalf("starting server failed: %v", err)
 		}
-		publicKey := ed25519.PublicKey(buf)
-		// Compatibility
-		r.Handle("POST /api/free", authHandler(http.HandlerFunc(freeMetrics), publicKey))
-		r.Handle("POST /api/write", authHandler(http.HandlerFunc(writeMetrics), publicKey))
-		r.Handle("GET /api/query", authHandler(http.HandlerFunc(handleQuery), publicKey))
-		r.Handle("GET /api/debug", authHandler(http.HandlerFunc(debugMetrics), publicKey))
-		r.Handle("GET /api/healthcheck", authHandler(http.HandlerFunc(metricsHealth), publicKey))
-		// Refactor
-		r.Handle("POST /api/free/", authHandler(http.HandlerFunc(freeMetrics), publicKey))
-		r.Handle("POST /api/write/", authHandler(http.HandlerFunc(writeMetrics), publicKey))
-		r.Handle("GET /api/query/", authHandler(http.HandlerFunc(handleQuery), publicKey))
-		r.Handle("GET /api/debug/", authHandler(http.HandlerFunc(debugMetrics), publicKey))
-		r.Handle("GET /api/healthcheck/", authHandler(http.HandlerFunc(metricsHealth), publicKey))
-	} else {
+		publicKey = ed25519.PublicKey(buf)
+	}
+
+	handle := func(pattern, endpoint, policy string, h http.HandlerFunc) {
+		var handler http.Handler = h
+		if publicKey != nil && !(policy == "metrics" && config.Keys.PublicMetrics) {
+			handler = authHandler(handler, publicKey, routeRoles(policy))
+		}
+		r.Handle(pattern, instrument(endpoint, handler))
+	}
+
+	for _, rt := range routes {
 		// Compatibility
-		r.HandleFunc("POST /api/free", freeMetrics)
-		r.HandleFunc("POST /api/write", writeMetrics)
-		r.HandleFunc("GET /api/query", handleQuery)
-		r.HandleFunc("GET /api/debug", debugMetrics)
-		r.HandleFunc("GET /api/healthcheck", metricsHealth)
+		handle(rt.method+" "+rt.path, rt.endpoint, rt.policy, rt.handler)
 		// Refactor
-		r.HandleFunc("POST /api/free/", freeMetrics)
-		r.HandleFunc("POST /api/write/", writeMetrics)
-		r.HandleFunc("GET /api/query/", handleQuery)
-		r.HandleFunc("GET /api/debug/", debugMetrics)
-		r.HandleFunc("GET /api/healthcheck/", metricsHealth)
+		handle(rt.method+" "+rt.path+"/", rt.endpoint, rt.policy, rt.handler)
 	}
+
+	handle("GET /metrics", "metrics", "metrics", handleMetrics)
 }
@@ -0,0 +1,130 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+)
+
+// statsSpec is the parsed `stats` list of a query request.
+type statsSpec struct {
+	median      bool
+	stddev      bool
+	count       bool
+	nanCount    bool
+	percentiles []percentile
+}
+
+type percentile struct {
+	name string  // As requested, e.g. "p90"
+	p    float64 // In [0, 1]
+}
+
+// parseStats validates the names in the `stats` list of a query request.
+// Percentiles are given as `p<N>` with 0 < N < 100, e.g. `p10` or `p99.9`.
+func parseStats(names []string) (*statsSpec, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	spec := &statsSpec{}
+	for _, name := range names {
+		switch name {
+		case "median":
+			spec.median = true
+		case "stddev":
+			spec.stddev = true
+		case "count":
+			spec.count = true
+		case "nan-count":
+			spec.nanCount = true
+		default:
+			raw, ok := strings.CutPrefix(name, "p")
+			if !ok {
+				return nil, fmt.Errorf("unknown statistic '%s'", name)
+			}
+			n, err := strconv.ParseFloat(raw, 64)
+			if err != nil || !(n > 0 && n < 100) {
+				return nil, fmt.Errorf("invalid percentile '%s'", name)
+			}
+			spec.percentiles = append(spec.percentiles, percentile{name: name, p: n / 100})
+		}
+	}
+	return spec, nil
+}
+
+// AddExtendedStats computes the statistics of spec that go beyond
+// avg/min/max. NaN values are ignored, except for the NaN count. Percentiles
+// are interpolated linearly between the closest ranks.
+func (data *APIMetricData) AddExtendedStats(spec *statsSpec) {
+	values := make([]float64, 0, len(data.Data))
+	sum := 0.0
+	for _, x := range data.Data {
+		if x.IsNaN() {
+			continue
+		}
+		values = append(values, float64(x))
+		sum += float64(x)
+	}
+
+	if spec.count {
+		n := len(values)
+		data.Count = &n
+	}
+	if spec.nanCount {
+		n := len(data.Data) - len(values)
+		data.NaNCount = &n
+	}
+
+	if spec.stddev {
+		stddev := schema.NaN
+		if len(values) > 0 {
+			mean, sq := sum/float64(len(values)), 0.0
+			for _, x := range values {
+				sq += (x - mean) * (x - mean)
+			}
+			stddev = schema.Float(math.Sqrt(sq / float64(len(values))))
+		}
+		data.Stddev = &stddev
+	}
+
+	if !spec.median && len(spec.percentiles) == 0 {
+		return
+	}
+
+	slices.Sort(values)
+	if spec.median {
+		median := quantile(values, 0.5)
+		data.Median = &median
+	}
+	if len(spec.percentiles) > 0 {
+		data.Percentiles = make(map[string]schema.Float, len(spec.percentiles))
+		for _, p := range spec.percentiles {
+			data.Percentiles[p.name] = quantile(values, p.p)
+		}
+	}
+}
+
+// quantile returns the p-quantile of the sorted values.
+func quantile(sorted []float64, p float64) schema.Float {
+	if len(sorted) == 0 {
+		return schema.NaN
+	}
+
+	pos := p * float64(len(sorted)-1)
+	i := int(pos)
+	if i+1 >= len(sorted) {
+		return schema.Float(sorted[len(sorted)-1])
+	}
+	frac := pos - float64(i)
+	return schema.Float(sorted[i] + frac*(sorted[i+1]-sorted[i]))
+}
@@ -0,0 +1,104 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+)
+
+func TestParseStats(t *testing.T) {
+	spec, err := parseStats([]string{"median", "count", "p10", "p99.9"})
+	if err != nil {
+		t.Fatalf("parseStats: %v", err)
+	}
+	if !spec.median || !spec.count || spec.stddev || spec.nanCount {
+		t.Errorf("unexpected flags %+v", spec)
+	}
+	want := []percentile{{"p10", 0.1}, {"p99.9", 0.999}}
+	if len(spec.percentiles) != len(want) {
+		t.Fatalf("percentiles %v, want %v", spec.percentiles, want)
+	}
+	for i, p := range want {
+		if spec.percentiles[i].name != p.name || math.Abs(spec.percentiles[i].p-p.p) > 1e-12 {
+			t.Errorf("percentile %d: %v, want %v", i, spec.percentiles[i], p)
+		}
+	}
+
+	for _, name := range []string{"mean", "p0", "p100", "p-5", "pxx", "p"} {
+		if _, err := parseStats([]string{name}); err == nil {
+			t.Errorf("parseStats(%q): no error", name)
+		}
+	}
+
+	if spec, err := parseStats(nil); spec != nil || err != nil {
+		t.Errorf("parseStats(nil) = %v, %v", spec, err)
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	tests := []struct {
+		p    float64
+		want float64
+	}{
+		{0.5, 5.5},
+		{0.9, 9.1},
+		{0.1, 1.9},
+		{0.25, 3.25},
+		{0.999, 9.991},
+	}
+	for _, tt := range tests {
+		if got := float64(quantile(sorted, tt.p)); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("quantile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+
+	if got := quantile([]float64{42}, 0.9); got != 42 {
+		t.Errorf("quantile of a single value = %v, want 42", got)
+	}
+	if got := quantile(nil, 0.5); !got.IsNaN() {
+		t.Errorf("quantile of no values = %v, want NaN", got)
+	}
+}
+
+func TestAddExtendedStats(t *testing.T) {
+	spec, err := parseStats([]string{"median", "stddev", "count", "nan-count", "p90"})
+	if err != nil {
+		t.Fatalf("parseStats: %v", err)
+	}
+	data := &APIMetricData{Data: []schema.Float{4, schema.NaN, 2, 8, schema.NaN, 6}}
+	data.AddExtendedStats(spec)
+
+	if data.Count == nil || *data.Count != 4 {
+		t.Errorf("count %v, want 4", data.Count)
+	}
+	if data.NaNCount == nil || *data.NaNCount != 2 {
+		t.Errorf("nan-count %v, want 2", data.NaNCount)
+	}
+	if data.Median == nil || *data.Median != 5 {
+		t.Errorf("median %v, want 5", data.Median)
+	}
+	// Population standard deviation of 2, 4, 6 and 8.
+	if data.Stddev == nil || math.Abs(float64(*data.Stddev)-math.Sqrt(5)) > 1e-9 {
+		t.Errorf("stddev %v, want %v", data.Stddev, math.Sqrt(5))
+	}
+	if p := data.Percentiles["p90"]; math.Abs(float64(p)-7.4) > 1e-9 {
+		t.Errorf("p90 %v, want 7.4", p)
+	}
+	// The data itself is not reordered.
+	if data.Data[0] != 4 || data.Data[2] != 2 {
+		t.Errorf("data was modified: %v", data.Data)
+	}
+
+	empty := &APIMetricData{Data: []schema.Float{schema.NaN}}
+	empty.AddExtendedStats(spec)
+	if !empty.Median.IsNaN() || !empty.Stddev.IsNaN() || !empty.Percentiles["p90"].IsNaN() {
+		t.Errorf("stats of NaN data: median %v, stddev %v, p90 %v", *empty.Median, *empty.Stddev, empty.Percentiles["p90"])
+	}
+}
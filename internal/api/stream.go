@@ -0,0 +1,76 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+)
+
+// streamFlushInterval is the number of query results after which a streamed
+// response is flushed to the client. It is also the number of queries that
+// are read concurrently.
+const streamFlushInterval = 64
+
+// streamQueryResponse writes the response to req incrementally: every batch
+// of query results is encoded as soon as it has been read from the memory
+// store and is dropped afterwards, so memory usage stays bounded by a single
+// batch instead of the whole response. The output has the same shape as an
+// encoded APIQueryResponse.
+func streamQueryResponse(rw http.ResponseWriter, r *http.Request, ms *metricstore.MemoryStore, req *APIQueryRequest, queries []APIQuery) {
+	rw.Header().Set("Content-Type", "application/json")
+	flusher, _ := rw.(http.Flusher)
+	bw := bufio.NewWriter(rw)
+	defer bw.Flush()
+	enc := json.NewEncoder(bw)
+
+	bw.WriteByte('{')
+	if len(queries) > 0 {
+		bw.WriteString(`"queries":`)
+		if err := enc.Encode(queries); err != nil {
+			cclog.Errorf("streaming query response: %s", err.Error())
+			return
+		}
+		bw.WriteByte(',')
+	}
+
+	bw.WriteString(`"results":[`)
+	// Queries are read in batches of streamFlushInterval so that the
+	// selectors of a batch can be evaluated concurrently while only one
+	// batch of results is held in memory at a time.
+	for start := 0; start < len(req.Queries); start += streamFlushInterval {
+		end := min(start+streamFlushInterval, len(req.Queries))
+		results, err := readQueries(r.Context(), ms, req, req.Queries[start:end])
+		if err != nil {
+			// The client went away, there is nobody left to read the rest.
+			cclog.Warnf("streaming query response aborted after %d of %d queries: %s", start, len(req.Queries), err.Error())
+			return
+		}
+
+		for i, res := range results {
+			if start+i > 0 {
+				bw.WriteByte(',')
+			}
+			if err := enc.Encode(res); err != nil {
+				cclog.Errorf("streaming query response: %s", err.Error())
+				return
+			}
+		}
+
+		if err := bw.Flush(); err != nil {
+			cclog.Errorf("streaming query response: %s", err.Error())
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	bw.WriteString("]}\n")
+}
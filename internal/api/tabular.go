@@ -0,0 +1,211 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// This file implements the tabular output formats of /api/query. Instead of
+// the nested JSON response, one row per data point is written in long format
+// (cluster, host, type, type-id, metric, timestamp, value), like the rows of
+// the Parquet archive.
+
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ClusterCockpit/cc-lib/v2/util"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+const (
+	mimeCSV   = "text/csv"
+	mimeArrow = "application/vnd.apache.arrow.stream"
+)
+
+// seriesLabels identifies the series of a result row. For selectors of
+// several type ids (aggregated queries), typeID lists them separated by
+// commas. For selectors of a subtype, type and typeID describe the subtype.
+type seriesLabels struct {
+	host, typ, typeID string
+}
+
+func selectorLabels(query APIQuery, sel util.Selector) seriesLabels {
+	l := seriesLabels{}
+	if len(sel) > 1 {
+		l.host = sel[1].String
+	}
+
+	typ, level := query.Type, 2
+	if query.SubType != nil && len(sel) > 3 {
+		typ, level = query.SubType, 3
+	}
+	if typ == nil || len(sel) <= level {
+		return l
+	}
+
+	ids := slices.Clone(sel[level].Group)
+	if sel[level].String != "" {
+		ids = []string{sel[level].String}
+	}
+	for i, id := range ids {
+		ids[i] = strings.TrimPrefix(id, *typ)
+	}
+	l.typ, l.typeID = *typ, strings.Join(ids, ",")
+	return l
+}
+
+// mergeLabels returns the labels shared by all series, the others are empty.
+func mergeLabels(series []APIMetricData) seriesLabels {
+	l := series[0].labels
+	for _, s := range series[1:] {
+		if s.labels.host != l.host {
+			l.host = ""
+		}
+		if s.labels.typ != l.typ {
+			l.typ = ""
+		}
+		if s.labels.typeID != l.typeID {
+			l.typeID = ""
+		}
+	}
+	return l
+}
+
+// tabularFormat returns the tabular format requested in the Accept header, or
+// an empty string for JSON. Of the supported media types, the one with the
+// highest q-value wins, the first one listed on a tie. Wildcards select JSON.
+func tabularFormat(r *http.Request) string {
+	format, best := "", 0.0
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(raw, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		if q <= best {
+			continue
+		}
+
+		switch mt {
+		case mimeCSV, mimeArrow:
+			format, best = mt, q
+		case "application/json", "application/*", "*/*":
+			format, best = "", q
+		}
+	}
+	return format
+}
+
+// tabularSchema describes the columns of the tabular formats.
+var tabularSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "cluster", Type: arrow.BinaryTypes.String},
+	{Name: "host", Type: arrow.BinaryTypes.String},
+	{Name: "type", Type: arrow.BinaryTypes.String, Nullable: true},
+	{Name: "type-id", Type: arrow.BinaryTypes.String, Nullable: true},
+	{Name: "metric", Type: arrow.BinaryTypes.String},
+	{Name: "timestamp", Type: &arrow.TimestampType{Unit: arrow.Second, TimeZone: "UTC"}},
+	{Name: "value", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+}, nil)
+
+// writeTabularResponse writes the results of queries as CSV or Arrow stream.
+// NaN values are written as empty fields or nulls.
+func writeTabularResponse(rw http.ResponseWriter, format string, req *APIQueryRequest, queries []APIQuery, results [][]APIMetricData) error {
+	rw.Header().Set("Content-Type", format)
+	bw := bufio.NewWriter(rw)
+	defer bw.Flush()
+
+	if format == mimeCSV {
+		w := csv.NewWriter(bw)
+		header := make([]string, 0, tabularSchema.NumFields())
+		for _, f := range tabularSchema.Fields() {
+			header = append(header, f.Name)
+		}
+		w.Write(header)
+
+		for i, res := range results {
+			for _, data := range res {
+				if data.Error != nil {
+					continue
+				}
+				for j, v := range data.Data {
+					value := ""
+					if !v.IsNaN() {
+						value = strconv.FormatFloat(float64(v), 'f', -1, 64)
+					}
+					ts := data.From + int64(j)*data.Resolution
+					w.Write([]string{
+						req.Cluster, data.labels.host, data.labels.typ, data.labels.typeID,
+						queries[i].Metric, strconv.FormatInt(ts, 10), value,
+					})
+				}
+			}
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	w := ipc.NewWriter(bw, ipc.WithSchema(tabularSchema))
+	b := array.NewRecordBuilder(memory.DefaultAllocator, tabularSchema)
+	defer b.Release()
+
+	cluster := b.Field(0).(*array.StringBuilder)
+	host := b.Field(1).(*array.StringBuilder)
+	typ := b.Field(2).(*array.StringBuilder)
+	typeID := b.Field(3).(*array.StringBuilder)
+	metric := b.Field(4).(*array.StringBuilder)
+	timestamp := b.Field(5).(*array.TimestampBuilder)
+	value := b.Field(6).(*array.Float64Builder)
+
+	// One record batch per query.
+	for i, res := range results {
+		for _, data := range res {
+			if data.Error != nil {
+				continue
+			}
+			for j, v := range data.Data {
+				cluster.Append(req.Cluster)
+				host.Append(data.labels.host)
+				if data.labels.typ != "" {
+					typ.Append(data.labels.typ)
+					typeID.Append(data.labels.typeID)
+				} else {
+					typ.AppendNull()
+					typeID.AppendNull()
+				}
+				metric.Append(queries[i].Metric)
+				timestamp.Append(arrow.Timestamp(data.From + int64(j)*data.Resolution))
+				if v.IsNaN() {
+					value.AppendNull()
+				} else {
+					value.Append(float64(v))
+				}
+			}
+		}
+		if cluster.Len() == 0 {
+			continue
+		}
+
+		rec := b.NewRecordBatch()
+		err := w.Write(rec)
+		rec.Release()
+		if err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
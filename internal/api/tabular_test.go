@@ -0,0 +1,188 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+)
+
+func TestTabularFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"application/json", ""},
+		{"text/csv", mimeCSV},
+		{"application/vnd.apache.arrow.stream", mimeArrow},
+		{"text/html, text/csv", mimeCSV},
+		{"text/csv, application/vnd.apache.arrow.stream", mimeCSV},
+		{"text/csv;q=0.5, application/vnd.apache.arrow.stream", mimeArrow},
+		{"application/vnd.apache.arrow.stream;q=0.8, text/csv;q=0.9", mimeCSV},
+		{"text/csv;q=0.5, application/json", ""},
+		{"text/csv;q=0.5, */*;q=0.1", mimeCSV},
+		{"*/*, text/csv;q=0.9", ""},
+		{"text/csv;q=0", ""},
+		{"text/csv;q=abc, application/vnd.apache.arrow.stream;q=0.1", mimeArrow},
+		{"text/csv; charset=utf-8", mimeCSV},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/query/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := tabularFormat(r); got != tt.want {
+			t.Errorf("Accept %q: format %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func tabularTestResults() (*APIQueryRequest, []APIQuery, [][]APIMetricData) {
+	errMsg := "failed"
+	req := &APIQueryRequest{Cluster: "fritz"}
+	queries := []APIQuery{{Metric: "cpu_load"}, {Metric: "flops_any"}}
+	results := [][]APIMetricData{
+		{
+			{From: 1000, Resolution: 60, Data: schema.FloatArray{1.5, schema.NaN}, labels: seriesLabels{host: "f0101"}},
+			{Error: &errMsg},
+		},
+		{
+			{From: 1060, Resolution: 60, Data: schema.FloatArray{42}, labels: seriesLabels{host: "f0102", typ: "socket", typeID: "0,1"}},
+		},
+	}
+	return req, queries, results
+}
+
+func TestWriteTabularResponseCSV(t *testing.T) {
+	req, queries, results := tabularTestResults()
+	rw := httptest.NewRecorder()
+	if err := writeTabularResponse(rw, mimeCSV, req, queries, results); err != nil {
+		t.Fatalf("writeTabularResponse: %v", err)
+	}
+
+	want := "cluster,host,type,type-id,metric,timestamp,value\n" +
+		"fritz,f0101,,,cpu_load,1000,1.5\n" +
+		"fritz,f0101,,,cpu_load,1060,\n" +
+		"fritz,f0102,socket,\"0,1\",flops_any,1060,42\n"
+	if got := rw.Body.String(); got != want {
+		t.Errorf("body\n%s\nwant\n%s", got, want)
+	}
+	if ct := rw.Header().Get("Content-Type"); ct != mimeCSV {
+		t.Errorf("content type %q", ct)
+	}
+}
+
+// TestWriteTabularResponseArrow reads the stream written by
+// writeTabularResponse back with the Arrow reader.
+func TestWriteTabularResponseArrow(t *testing.T) {
+	req, queries, results := tabularTestResults()
+	rw := httptest.NewRecorder()
+	if err := writeTabularResponse(rw, mimeArrow, req, queries, results); err != nil {
+		t.Fatalf("writeTabularResponse: %v", err)
+	}
+
+	r, err := ipc.NewReader(bytes.NewReader(rw.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("ipc.NewReader: %v", err)
+	}
+	defer r.Release()
+
+	wantSchema := arrow.NewSchema([]arrow.Field{
+		{Name: "cluster", Type: arrow.BinaryTypes.String},
+		{Name: "host", Type: arrow.BinaryTypes.String},
+		{Name: "type", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "type-id", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "metric", Type: arrow.BinaryTypes.String},
+		{Name: "timestamp", Type: &arrow.TimestampType{Unit: arrow.Second, TimeZone: "UTC"}},
+		{Name: "value", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+	}, nil)
+	if !r.Schema().Equal(wantSchema) {
+		t.Fatalf("schema\n%v\nwant\n%v", r.Schema(), wantSchema)
+	}
+
+	type row struct {
+		cluster, host, typ, typeID, metric string
+		ts                                 int64
+		value                              float64
+	}
+	rows := []row{}
+	batches := 0
+	for r.Next() {
+		rec := r.RecordBatch()
+		batches++
+		cols := make([]*array.String, 5)
+		for i := range cols {
+			cols[i] = rec.Column(i).(*array.String)
+		}
+		ts := rec.Column(5).(*array.Timestamp)
+		values := rec.Column(6).(*array.Float64)
+		for i := range int(rec.NumRows()) {
+			str := func(c int) string {
+				if cols[c].IsNull(i) {
+					return "<null>"
+				}
+				return cols[c].Value(i)
+			}
+			v := math.NaN()
+			if values.IsValid(i) {
+				v = values.Value(i)
+			}
+			rows = append(rows, row{str(0), str(1), str(2), str(3), str(4), int64(ts.Value(i)), v})
+		}
+	}
+	if err := r.Err(); err != nil {
+		t.Fatalf("reading stream: %v", err)
+	}
+
+	if batches != 2 {
+		t.Errorf("%d record batches, want 2", batches)
+	}
+	want := []row{
+		{"fritz", "f0101", "<null>", "<null>", "cpu_load", 1000, 1.5},
+		{"fritz", "f0101", "<null>", "<null>", "cpu_load", 1060, math.NaN()},
+		{"fritz", "f0102", "socket", "0,1", "flops_any", 1060, 42},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows %v, want %v", rows, want)
+	}
+	for i, w := range want {
+		g := rows[i]
+		same := g.value == w.value || (math.IsNaN(g.value) && math.IsNaN(w.value))
+		g.value, w.value = 0, 0
+		if g != w || !same {
+			t.Errorf("row %d: %v, want %v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestWriteTabularResponseArrowEmpty(t *testing.T) {
+	req, queries, _ := tabularTestResults()
+	rw := httptest.NewRecorder()
+	if err := writeTabularResponse(rw, mimeArrow, req, queries, [][]APIMetricData{{}, {}}); err != nil {
+		t.Fatalf("writeTabularResponse: %v", err)
+	}
+
+	r, err := ipc.NewReader(bytes.NewReader(rw.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("ipc.NewReader: %v", err)
+	}
+	defer r.Release()
+	if r.Next() {
+		t.Error("record batch in empty stream")
+	}
+	if !r.Schema().Equal(tabularSchema) {
+		t.Errorf("schema %v", r.Schema())
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// This file implements the self-monitoring endpoint `/metrics` in the
+// Prometheus text exposition format. Only the few metric types needed here
+// (labelled counters, histograms and gauges evaluated at scrape time) are
+// implemented, which avoids pulling in the Prometheus client library.
+
+package api
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	"github.com/ClusterCockpit/cc-lib/v2/nats"
+)
+
+// defaultLatencyBuckets are the upper bounds (in seconds) of the request
+// latency histogram buckets.
+var defaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// counter is a monotonically increasing value, partitioned by label values.
+type counter struct {
+	name, help string
+	labels     []string
+	series     sync.Map // joined label values -> *counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	n      atomic.Uint64
+}
+
+func (c *counter) add(n uint64, values ...string) {
+	key := strings.Join(values, "\xff")
+	s, ok := c.series.Load(key)
+	if !ok {
+		s, _ = c.series.LoadOrStore(key, &counterSeries{values: values})
+	}
+	s.(*counterSeries).n.Add(n)
+}
+
+func (c *counter) inc(values ...string) {
+	c.add(1, values...)
+}
+
+// histogram counts observations in cumulative buckets, partitioned by label
+// values.
+type histogram struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+	series     sync.Map // joined label values -> *histogramSeries
+}
+
+type histogramSeries struct {
+	values  []string
+	buckets []atomic.Uint64
+	count   atomic.Uint64
+	sumBits atomic.Uint64
+}
+
+func (h *histogram) observe(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	s, ok := h.series.Load(key)
+	if !ok {
+		s, _ = h.series.LoadOrStore(key, &histogramSeries{
+			values:  values,
+			buckets: make([]atomic.Uint64, len(h.buckets)),
+		})
+	}
+
+	hs := s.(*histogramSeries)
+	for i, ub := range h.buckets {
+		if v <= ub {
+			hs.buckets[i].Add(1)
+		}
+	}
+	hs.count.Add(1)
+	for {
+		old := hs.sumBits.Load()
+		if hs.sumBits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			break
+		}
+	}
+}
+
+// gauge is a value that is computed when the endpoint is scraped.
+type gauge struct {
+	name, help string
+	value      func() float64
+}
+
+var (
+	httpRequests = &counter{
+		name:   "ccms_http_requests_total",
+		help:   "Number of HTTP requests handled, by endpoint and status code.",
+		labels: []string{"endpoint", "code"},
+	}
+	httpRequestDuration = &histogram{
+		name:    "ccms_http_request_duration_seconds",
+		help:    "Latency of HTTP requests, by endpoint.",
+		labels:  []string{"endpoint"},
+		buckets: defaultLatencyBuckets,
+	}
+	writeLines = &counter{
+		name:   "ccms_write_lines_total",
+		help:   "Number of line-protocol lines received, by endpoint (write, prom-write).",
+		labels: []string{"endpoint"},
+	}
+	writeDecodeErrors = &counter{
+		name:   "ccms_write_decode_errors_total",
+		help:   "Number of write requests aborted because of a decode error, by endpoint.",
+		labels: []string{"endpoint"},
+	}
+	writeRejectedLines = &counter{
+		name:   "ccms_write_rejected_lines_total",
+		help:   "Number of line-protocol lines skipped by writes with report=true, by endpoint.",
+		labels: []string{"endpoint"},
+	}
+	writeUnknownLines = &counter{
+		name:   "ccms_write_unknown_metric_lines_total",
+		help:   "Number of lines of metrics missing in the metrics section, by endpoint and unknown-metrics policy.",
+		labels: []string{"endpoint", "policy"},
+	}
+	writeInvalidValues = &counter{
+		name:   "ccms_write_invalid_values_total",
+		help:   "Number of samples rejected by the range (min, max) and scopes of the metrics section, by endpoint and reason (range, scope).",
+		labels: []string{"endpoint", "reason"},
+	}
+	authFailures = &counter{
+		name:   "ccms_auth_failures_total",
+		help:   "Number of rejected requests, by reason (unauthorized, forbidden).",
+		labels: []string{"reason"},
+	}
+
+	// tokenCacheEntries is the number of validated JWTs held in the caches of
+	// all authHandler instances.
+	tokenCacheEntries atomic.Int64
+
+	// memoryStoreSize is the size of the memory store as of the last refresh
+	// by TrackMemoryStoreSize. Computing it walks the whole tree under its
+	// locks, which is too expensive for every scrape.
+	memoryStoreSize atomic.Int64
+)
+
+// memoryStoreSizeInterval is how often TrackMemoryStoreSize refreshes
+// memoryStoreSize.
+const memoryStoreSizeInterval = time.Minute
+
+var (
+	counters   = []*counter{httpRequests, writeLines, writeDecodeErrors, writeRejectedLines, writeUnknownLines, writeInvalidValues, authFailures}
+	histograms = []*histogram{httpRequestDuration}
+	gauges     = []gauge{
+		{
+			name:  "ccms_token_cache_entries",
+			help:  "Number of validated JWTs in the authentication caches.",
+			value: func() float64 { return float64(tokenCacheEntries.Load()) },
+		},
+		{
+			name:  "ccms_memorystore_size_bytes",
+			help:  "Size of the metric data held in the memory store, refreshed every minute.",
+			value: func() float64 { return float64(memoryStoreSize.Load()) },
+		},
+		{
+			name:  "ccms_nats_messages_received_total",
+			help:  "Number of messages received by the NATS connection.",
+			value: func() float64 { msgs, _ := natsReceived(); return float64(msgs) },
+		},
+		{
+			name:  "ccms_nats_bytes_received_total",
+			help:  "Number of bytes received by the NATS connection.",
+			value: func() float64 { _, bytes := natsReceived(); return float64(bytes) },
+		},
+		{
+			name:  "go_goroutines",
+			help:  "Number of goroutines that currently exist.",
+			value: func() float64 { return float64(runtime.NumGoroutine()) },
+		},
+		{
+			name: "go_memstats_heap_alloc_bytes",
+			help: "Number of heap bytes allocated and still in use.",
+			value: func() float64 {
+				var mem runtime.MemStats
+				runtime.ReadMemStats(&mem)
+				return float64(mem.HeapAlloc)
+			},
+		},
+	}
+)
+
+// TrackMemoryStoreSize refreshes the memory store size exposed at /metrics
+// every memoryStoreSizeInterval until ctx is cancelled.
+func TrackMemoryStoreSize(ctx context.Context, wg *sync.WaitGroup) {
+	ms := metricstore.GetMemoryStore()
+	memoryStoreSize.Store(ms.SizeInBytes())
+
+	wg.Go(func() {
+		ticker := time.NewTicker(memoryStoreSizeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				memoryStoreSize.Store(ms.SizeInBytes())
+			}
+		}
+	})
+}
+
+// natsReceived returns the number of messages and bytes received by the NATS
+// connection. The messages are decoded by metricstore.ReceiveNats, which
+// cannot be hooked into, so they are counted by the connection.
+func natsReceived() (msgs, bytes uint64) {
+	nc := nats.GetClient()
+	if nc == nil || nc.Connection() == nil {
+		return 0, 0
+	}
+	stats := nc.Connection().Stats()
+	return stats.InMsgs, stats.InBytes
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+// Flush keeps streamed responses working through the recorder.
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// instrument records the number and latency of requests to the endpoint.
+func instrument(endpoint string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: rw}
+		next.ServeHTTP(sr, r)
+
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		httpRequests.inc(endpoint, strconv.Itoa(sr.status))
+		httpRequestDuration.observe(time.Since(start).Seconds(), endpoint)
+	})
+}
+
+// handleMetrics serves the self-monitoring metrics in the Prometheus text
+// exposition format.
+func handleMetrics(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(rw)
+	defer bw.Flush()
+
+	for _, c := range counters {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, s := range sortedSeries[*counterSeries](&c.series) {
+			fmt.Fprintf(bw, "%s%s %d\n", c.name, formatLabels(c.labels, s.values, ""), s.n.Load())
+		}
+	}
+
+	for _, h := range histograms {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+		for _, s := range sortedSeries[*histogramSeries](&h.series) {
+			for i, ub := range h.buckets {
+				le := strconv.FormatFloat(ub, 'g', -1, 64)
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.values, le), s.buckets[i].Load())
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.values, "+Inf"), s.count.Load())
+			fmt.Fprintf(bw, "%s_sum%s %g\n", h.name, formatLabels(h.labels, s.values, ""), math.Float64frombits(s.sumBits.Load()))
+			fmt.Fprintf(bw, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.values, ""), s.count.Load())
+		}
+	}
+
+	for _, g := range gauges {
+		typ := "gauge"
+		if strings.HasSuffix(g.name, "_total") {
+			typ = "counter"
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", g.name, g.help, g.name, typ, g.name, g.value())
+	}
+
+	if err := bw.Flush(); err != nil {
+		cclog.Errorf("writing /metrics response: %s", err.Error())
+	}
+}
+
+// sortedSeries returns the series stored in m ordered by their label values,
+// so that the output is stable between scrapes.
+func sortedSeries[T any](m *sync.Map) []T {
+	keys := []string{}
+	values := map[string]T{}
+	m.Range(func(k, v any) bool {
+		keys = append(keys, k.(string))
+		values[k.(string)] = v.(T)
+		return true
+	})
+	slices.Sort(keys)
+
+	res := make([]T, 0, len(keys))
+	for _, k := range keys {
+		res = append(res, values[k])
+	}
+	return res
+}
+
+// formatLabels renders a label set. If le is not empty, it is appended as
+// the histogram bucket label.
+func formatLabels(names, values []string, le string) string {
+	if len(names) == 0 && le == "" {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabelValue(values[i]))
+		sb.WriteByte('"')
+	}
+	if le != "" {
+		if len(names) > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(`le="`)
+		sb.WriteString(le)
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
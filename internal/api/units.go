@@ -0,0 +1,64 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// This file implements the `target-unit` of a query, which converts the
+// values from the unit configured for the metric, e.g. from `W` to `kW` or
+// from `Bytes/s` to `GB/s`.
+
+package api
+
+import (
+	"fmt"
+	"math"
+
+	ccunits "github.com/ClusterCockpit/cc-lib/v2/ccUnits"
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
+)
+
+// unitConverter returns the function converting values of metric to the
+// unit target. Units are parsed by ccUnits, so prefixes and spellings like
+// `kB`, `kByte` and `KBytes` are understood.
+func unitConverter(metric, target string) (func(float64) float64, error) {
+	s, _ := config.GetMetricSchema(metric)
+	if s.Unit == "" {
+		return nil, fmt.Errorf("metric '%s' has no unit configured", metric)
+	}
+	in := ccunits.NewUnit(s.Unit)
+	if !in.Valid() {
+		return nil, fmt.Errorf("unit '%s' of metric '%s' cannot be converted", s.Unit, metric)
+	}
+	out := ccunits.NewUnit(target)
+	if !out.Valid() {
+		return nil, fmt.Errorf("unknown unit '%s'", target)
+	}
+
+	conv, err := ccunits.GetUnitUnitFactor(in, out)
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert '%s' to '%s'", s.Unit, target)
+	}
+	// The converters of ccUnits return the type they are passed, but this is
+	// not part of their signature.
+	if _, ok := conv(1.0).(float64); !ok {
+		return nil, fmt.Errorf("cannot convert '%s' to '%s'", s.Unit, target)
+	}
+	return func(v float64) float64 {
+		if f, ok := conv(v).(float64); ok {
+			return f
+		}
+		return math.NaN()
+	}, nil
+}
+
+// convertUnit converts the data of the series with conv, which is the
+// converter for unit. Values conv cannot convert become null.
+func (data *APIMetricData) convertUnit(conv func(float64) float64, unit string) {
+	for i, v := range data.Data {
+		if !v.IsNaN() {
+			data.Data[i] = schema.Float(conv(float64(v)))
+		}
+	}
+	data.Unit = unit
+}
@@ -0,0 +1,69 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
+)
+
+func TestUnitConverter(t *testing.T) {
+	config.InitMetrics(json.RawMessage(`{
+		"power": { "frequency": 60, "aggregation": "sum", "unit": "W" },
+		"mem_bw": { "frequency": 60, "aggregation": "sum", "unit": "MBytes/s" },
+		"cpu_load": { "frequency": 60, "aggregation": null }
+	}`))
+	t.Cleanup(func() { config.InitMetrics(json.RawMessage(`{}`)) })
+
+	tests := []struct {
+		metric, target string
+		in, want       float64
+	}{
+		{"power", "kW", 1500, 1.5},
+		{"power", "W", 1500, 1500},
+		{"mem_bw", "GBytes/s", 2500, 2.5},
+		{"mem_bw", "kB/s", 2, 2000},
+	}
+	for _, tt := range tests {
+		conv, err := unitConverter(tt.metric, tt.target)
+		if err != nil {
+			t.Errorf("unitConverter(%s, %s): %v", tt.metric, tt.target, err)
+			continue
+		}
+		if got := conv(tt.in); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s to %s: %v = %v, want %v", tt.metric, tt.target, tt.in, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct{ metric, target string }{
+		{"cpu_load", "W"},
+		{"power", "Bytes"},
+		{"power", "W/s"},
+		{"power", "furlong"},
+	} {
+		if _, err := unitConverter(tt.metric, tt.target); err == nil {
+			t.Errorf("unitConverter(%s, %s): no error", tt.metric, tt.target)
+		}
+	}
+}
+
+func TestConvertUnit(t *testing.T) {
+	data := &APIMetricData{Data: []schema.Float{1000, schema.NaN, 2500}, Unit: "W"}
+	data.convertUnit(func(v float64) float64 {
+		if v > 2000 {
+			return math.NaN()
+		}
+		return v / 1000
+	}, "kW")
+
+	if data.Unit != "kW" || data.Data[0] != 1 || !data.Data[1].IsNaN() || !data.Data[2].IsNaN() {
+		t.Errorf("converted %v %s", data.Data, data.Unit)
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// This file implements the `unknown-metrics` policy for lines of metrics
+// missing in the metrics section and `/api/unknown-metrics`, which lists the
+// unknown metrics seen recently.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	"github.com/ClusterCockpit/cc-line-protocol/v2/lineprotocol"
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
+)
+
+const (
+	// unknownMetricsWindow is how long an unknown metric is listed after it
+	// was last seen.
+	unknownMetricsWindow = 24 * time.Hour
+	// maxUnknownMetrics and maxUnknownHosts bound the memory used for the
+	// listing if a collector sends many different names.
+	maxUnknownMetrics = 1000
+	maxUnknownHosts   = 100
+)
+
+// UnknownMetric describes a metric that was written but is missing in the
+// metrics section.
+type UnknownMetric struct {
+	Name string `json:"name"`
+	// Number of samples received
+	Samples   int64 `json:"samples"`
+	FirstSeen int64 `json:"first-seen"`
+	LastSeen  int64 `json:"last-seen"`
+	// The metric was added by the 'register' policy and is stored since
+	Registered bool `json:"registered"`
+	// The hosts sending the metric, at most 100
+	Hosts []UnknownMetricHost `json:"hosts"`
+}
+
+// UnknownMetricHost is a host sending an unknown metric.
+type UnknownMetricHost struct {
+	Cluster string `json:"cluster"`
+	Host    string `json:"host"`
+	Samples int64  `json:"samples"`
+}
+
+type unknownMetricTracker struct {
+	lock    sync.Mutex
+	metrics map[string]*UnknownMetric
+}
+
+var unknownMetrics = &unknownMetricTracker{metrics: map[string]*UnknownMetric{}}
+
+// prune removes the metrics not seen within unknownMetricsWindow. The lock
+// must be held.
+func (t *unknownMetricTracker) prune(now int64) {
+	for name, um := range t.metrics {
+		if now-um.LastSeen > int64(unknownMetricsWindow.Seconds()) {
+			delete(t.metrics, name)
+		}
+	}
+}
+
+func (t *unknownMetricTracker) record(name, cluster, host string, n int64) {
+	now := time.Now().Unix()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	um, ok := t.metrics[name]
+	if !ok {
+		if len(t.metrics) >= maxUnknownMetrics {
+			t.prune(now)
+			if len(t.metrics) >= maxUnknownMetrics {
+				return
+			}
+		}
+		um = &UnknownMetric{Name: name, FirstSeen: now}
+		t.metrics[name] = um
+	}
+	um.Samples += n
+	um.LastSeen = now
+
+	for i := range um.Hosts {
+		if um.Hosts[i].Cluster == cluster && um.Hosts[i].Host == host {
+			um.Hosts[i].Samples += n
+			return
+		}
+	}
+	if len(um.Hosts) < maxUnknownHosts {
+		um.Hosts = append(um.Hosts, UnknownMetricHost{Cluster: cluster, Host: host, Samples: n})
+	}
+}
+
+// list returns copies of the recently seen unknown metrics, sorted by name.
+func (t *unknownMetricTracker) list() []UnknownMetric {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.prune(time.Now().Unix())
+
+	res := make([]UnknownMetric, 0, len(t.metrics))
+	for _, um := range t.metrics {
+		c := *um
+		c.Hosts = slices.Clone(um.Hosts)
+		slices.SortFunc(c.Hosts, func(a, b UnknownMetricHost) int {
+			return strings.Compare(a.Cluster+"/"+a.Host, b.Cluster+"/"+b.Host)
+		})
+		c.Registered = config.IsRegistered(c.Name)
+		res = append(res, c)
+	}
+	slices.SortFunc(res, func(a, b UnknownMetric) int { return strings.Compare(a.Name, b.Name) })
+	return res
+}
+
+// metricConfig returns the configuration of the metric name in ms. A
+// registered metric is stored in a reserved slot (see config.MetricSlot),
+// the slots themselves cannot be written or read by their name.
+func metricConfig(ms *metricstore.MemoryStore, name string) (metricstore.MetricConfig, bool) {
+	if config.IsReserved(name) {
+		return metricstore.MetricConfig{}, false
+	}
+	mc, ok := ms.Metrics[config.MetricSlot(name)]
+	return mc, ok
+}
+
+// metricConfigs returns the configuration of all metrics of ms by the names
+// they are written and queried with, including the registered metrics.
+func metricConfigs(ms *metricstore.MemoryStore) map[string]metricstore.MetricConfig {
+	res := make(map[string]metricstore.MetricConfig, len(ms.Metrics))
+	for slot, mc := range ms.Metrics {
+		if name := config.MetricName(slot); !config.IsReserved(name) {
+			res[name] = mc
+		}
+	}
+	return res
+}
+
+// unknownMetric applies the unknown-metrics policy to n samples of the
+// metric name, which is missing in the memory store. It returns true if the
+// samples can be written because the metric was registered, and an error if
+// the request has to be rejected.
+func unknownMetric(endpoint, name, cluster, host string, n int) (bool, error) {
+	policy := config.Keys.Unknown.Policy
+	if policy == "" || policy == "drop" {
+		return false, nil
+	}
+
+	unknownMetrics.record(name, cluster, host, int64(n))
+	writeUnknownLines.add(uint64(n), endpoint, policy)
+	switch policy {
+	case "reject":
+		return false, fmt.Errorf("unknown metric '%s'", name)
+	case "register":
+		if config.RegisterMetric(name) {
+			return true, nil
+		}
+		cclog.Debugf("%s: no slot left to register metric '%s'", endpoint, name)
+	}
+	return false, nil
+}
+
+// lineMeasurement returns the raw measurement of a line-protocol line.
+func lineMeasurement(line []byte) []byte {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case ',', ' ':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// lineSource decodes the metric name and the cluster and host tags of a
+// line. clusterDefault is used if the line has no cluster tag.
+func lineSource(line []byte, clusterDefault string) (name, cluster, host string, err error) {
+	dec := lineprotocol.NewDecoderWithBytes(line)
+	if !dec.Next() {
+		return "", "", "", io.ErrUnexpectedEOF
+	}
+	m, err := dec.Measurement()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	cluster = clusterDefault
+	for {
+		key, val, err := dec.NextTag()
+		if err != nil {
+			return "", "", "", err
+		}
+		if key == nil {
+			break
+		}
+		switch string(key) {
+		case "cluster":
+			cluster = string(val)
+		case "hostname", "host":
+			host = string(val)
+		}
+	}
+	return string(m), cluster, host, nil
+}
+
+// handleUnknownMetrics godoc
+// @summary Unknown metrics
+// @tags write
+// @description Lists the metrics missing in the metrics section that were
+// written within the last 24 hours, with the number of lines and the hosts
+// sending them. Metrics are only recorded if the `unknown-metrics` policy is
+// `count`, `reject` or `register`.
+// @produce     json
+// @success     200            {array}  UnknownMetric       "Unknown metrics, sorted by name"
+// @failure     401            {object} ErrorResponse       "Unauthorized"
+// @failure     403            {object} ErrorResponse       "Forbidden"
+// @security    ApiKeyAuth
+// @router      /unknown-metrics/ [get]
+func handleUnknownMetrics(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(unknownMetrics.list()); err != nil {
+		cclog.Errorf("Failed to encode unknown metrics: %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// queryRequestSchema is the JSON schema of APIQueryRequest. It is served by
+// handleQuerySchema.
+//
+//go:embed query-request.schema.json
+var queryRequestSchema string
+
+var compiledQueryRequestSchema = jsonschema.MustCompileString("query-request.schema.json", queryRequestSchema)
+
+// FieldError describes why a field of a request is invalid.
+type FieldError struct {
+	// Path of the field, e.g. "queries[0].type-ids"
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// validationError is returned for requests with invalid fields. handleError
+// lists the fields in the response.
+type validationError struct {
+	fields []FieldError
+}
+
+func (ve *validationError) add(field, format string, a ...any) {
+	ve.fields = append(ve.fields, FieldError{Field: field, Reason: fmt.Sprintf(format, a...)})
+}
+
+func (ve *validationError) Error() string {
+	msgs := make([]string, len(ve.fields))
+	for i, f := range ve.fields {
+		msgs[i] = f.Field + ": " + f.Reason
+	}
+	return "invalid request: " + strings.Join(msgs, "; ")
+}
+
+// orNil returns nil if no field error was added.
+func (ve *validationError) orNil() error {
+	if len(ve.fields) == 0 {
+		return nil
+	}
+	return ve
+}
+
+// fieldPath converts a JSON pointer like `/queries/0/type-ids` into the
+// notation used in field errors, `queries[0].type-ids`.
+func fieldPath(pointer string) string {
+	var sb strings.Builder
+	for _, tok := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		if tok == "" {
+			continue
+		}
+		tok = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+		if _, err := strconv.Atoi(tok); err == nil {
+			sb.WriteString("[" + tok + "]")
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(tok)
+	}
+	if sb.Len() == 0 {
+		return "(request)"
+	}
+	return sb.String()
+}
+
+// schemaFieldErrors collects the leaf errors of a schema validation.
+func schemaFieldErrors(err *jsonschema.ValidationError, ve *validationError) {
+	if len(err.Causes) == 0 {
+		ve.add(fieldPath(err.InstanceLocation), "%s", err.Message)
+		return
+	}
+	for _, cause := range err.Causes {
+		schemaFieldErrors(cause, ve)
+	}
+}
+
+// decodeQueryRequest reads a JSON query request from body and checks it
+// against queryRequestSchema before decoding it into req.
+func decodeQueryRequest(body io.Reader, req *APIQueryRequest) error {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	var doc any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return fmt.Errorf("parsing request body failed: %w", err)
+	}
+	if err := compiledQueryRequestSchema.Validate(doc); err != nil {
+		if schemaErr, ok := err.(*jsonschema.ValidationError); ok {
+			ve := &validationError{}
+			schemaFieldErrors(schemaErr, ve)
+			return ve
+		}
+		return err
+	}
+
+	return json.NewDecoder(bytes.NewReader(raw)).Decode(req)
+}
+
+// validateQueryRequest checks the semantics of a query request that the
+// schema cannot express, for both the JSON and the URL form, before any data
+// is read.
+func validateQueryRequest(ms *metricstore.MemoryStore, req *APIQueryRequest) error {
+	ve := &validationError{}
+	if req.Cluster == "" {
+		ve.add("cluster", "is required")
+	}
+	if req.From > req.To {
+		ve.add("to", "must not be before from (%d)", req.From)
+	}
+
+	if stats, err := parseStats(req.Stats); err != nil {
+		ve.add("stats", "%s", err.Error())
+	} else {
+		req.stats = stats
+	}
+	if err := checkOperator(req.ForAllNodesOperator); err != nil {
+		ve.add("for-all-nodes-operator", "%s", err.Error())
+	}
+
+	for i, q := range req.Queries {
+		field := func(name string) string {
+			return fmt.Sprintf("queries[%d].%s", i, name)
+		}
+
+		if q.Metric == "" {
+			ve.add(field("metric"), "is required")
+		}
+		if q.Hostname == "" && len(q.Hosts) == 0 {
+			ve.add(field("host"), "is required")
+		}
+		if q.Resolution < 0 {
+			ve.add(field("resolution"), "must not be negative")
+		} else if mc, ok := metricConfig(ms, q.Metric); ok && q.Resolution > mc.Frequency && q.Resolution%mc.Frequency != 0 {
+			ve.add(field("resolution"), "must be a multiple of the metric frequency (%d)", mc.Frequency)
+		}
+
+		switch {
+		case q.Type == nil && len(q.TypeIds) > 0:
+			ve.add(field("type-ids"), "requires type")
+		case q.Type != nil && len(q.TypeIds) == 0:
+			ve.add(field("type-ids"), "is required if type is set")
+		}
+		switch {
+		case q.SubType != nil && q.Type == nil:
+			ve.add(field("subtype"), "requires type")
+		case q.SubType == nil && len(q.SubTypeIds) > 0:
+			ve.add(field("subtype-ids"), "requires subtype")
+		case q.SubType != nil && len(q.SubTypeIds) == 0:
+			ve.add(field("subtype-ids"), "is required if subtype is set")
+		}
+
+		if err := checkOperator(q.Operator); err != nil {
+			ve.add(field("operator"), "%s", err.Error())
+		}
+		if err := checkDownsample(q.Downsample); err != nil {
+			ve.add(field("downsample"), "%s", err.Error())
+		}
+		if q.TargetUnit != "" {
+			if _, err := unitConverter(q.Metric, q.TargetUnit); err != nil {
+				ve.add(field("target-unit"), "%s", err.Error())
+			}
+		}
+	}
+
+	return ve.orNil()
+}
+
+// handleQuerySchema godoc
+// @summary JSON schema of the query request
+// @tags query
+// @description Returns the JSON schema the body of `/api/query/` is
+// validated against.
+// @produce     json
+// @success     200            {object} object  "JSON schema"
+// @failure     401            {object} ErrorResponse       "Unauthorized"
+// @failure     403            {object} ErrorResponse       "Forbidden"
+// @security    ApiKeyAuth
+// @router      /query/schema [get]
+func handleQuerySchema(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/schema+json")
+	io.WriteString(rw, queryRequestSchema)
+}
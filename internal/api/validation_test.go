@@ -0,0 +1,145 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+)
+
+// fieldErrors returns the field errors of err, sorted by field.
+func fieldErrors(t *testing.T, err error) []FieldError {
+	t.Helper()
+	var ve *validationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("error %v is no validation error", err)
+	}
+	fields := slices.Clone(ve.fields)
+	slices.SortFunc(fields, func(a, b FieldError) int { return strings.Compare(a.Field, b.Field) })
+	return fields
+}
+
+func TestFieldPath(t *testing.T) {
+	tests := map[string]string{
+		"":                         "(request)",
+		"/cluster":                 "cluster",
+		"/queries/0/type-ids/2":    "queries[0].type-ids[2]",
+		"/queries/10/subtype":      "queries[10].subtype",
+		"/a~1b/c~0d":               "a/b.c~d",
+		"/for-all-nodes/3":         "for-all-nodes[3]",
+		"/queries/1/target-unit/x": "queries[1].target-unit.x",
+	}
+	for pointer, want := range tests {
+		if got := fieldPath(pointer); got != want {
+			t.Errorf("fieldPath(%q) = %q, want %q", pointer, got, want)
+		}
+	}
+}
+
+func TestDecodeQueryRequestSchemaErrors(t *testing.T) {
+	tests := []struct {
+		body string
+		want []FieldError
+	}{
+		{
+			`{"cluster":"","from":"x","to":2,"bogus":1}`,
+			[]FieldError{
+				{"(request)", "additionalProperties 'bogus' not allowed"},
+				{"cluster", "length must be >= 1, but got 0"},
+				{"from", "expected integer, but got string"},
+			},
+		},
+		{
+			`{"cluster":"c","from":1,"to":2,"queries":[{"metric":"m","host":"h","type-ids":[1]}]}`,
+			[]FieldError{{"queries[0].type-ids[0]", "expected string, but got number"}},
+		},
+		{
+			`{"cluster":"c","from":1,"to":2,"queries":[{"metric":"m","host":"h","operator":"median","resolution":-1}]}`,
+			[]FieldError{
+				{"queries[0].operator", `value must be one of "", "sum", "avg", "min", "max", "stddev"`},
+				{"queries[0].resolution", "must be >= 0 but found -1"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		err := decodeQueryRequest(strings.NewReader(tt.body), &APIQueryRequest{})
+		if got := fieldErrors(t, err); !slices.Equal(got, tt.want) {
+			t.Errorf("%s:\n got %v\nwant %v", tt.body, got, tt.want)
+		}
+	}
+
+	err := decodeQueryRequest(strings.NewReader(`{"cluster":"c","from":1`), &APIQueryRequest{})
+	if err == nil || !strings.HasPrefix(err.Error(), "parsing request body failed") {
+		t.Errorf("truncated body: error %v", err)
+	}
+
+	req := &APIQueryRequest{}
+	if err := decodeQueryRequest(strings.NewReader(`{"cluster":"c","from":1,"to":2,"queries":[{"metric":"m","host":"h"}]}`), req); err != nil {
+		t.Fatalf("valid request: %v", err)
+	}
+	if req.Cluster != "c" || len(req.Queries) != 1 || req.Queries[0].Metric != "m" {
+		t.Errorf("decoded %+v", req)
+	}
+}
+
+func TestValidateQueryRequest(t *testing.T) {
+	ms := &metricstore.MemoryStore{Metrics: map[string]metricstore.MetricConfig{
+		"cpu_load": {Frequency: 60},
+	}}
+	socket := "socket"
+	req := &APIQueryRequest{
+		Cluster: "c",
+		From:    200,
+		To:      100,
+		Stats:   []string{"p100"},
+		Queries: []APIQuery{
+			{Metric: "cpu_load", Hostname: "h", Resolution: 90},
+			{Metric: "cpu_load", TypeIds: []string{"0"}},
+			{Metric: "cpu_load", Hostname: "h", Type: &socket, Downsample: "median"},
+		},
+	}
+
+	want := []FieldError{
+		{"queries[0].resolution", "must be a multiple of the metric frequency (60)"},
+		{"queries[1].host", "is required"},
+		{"queries[1].type-ids", "requires type"},
+		{"queries[2].downsample", "unknown downsample function 'median'"},
+		{"queries[2].type-ids", "is required if type is set"},
+		{"stats", "invalid percentile 'p100'"},
+		{"to", "must not be before from (200)"},
+	}
+	if got := fieldErrors(t, validateQueryRequest(ms, req)); !slices.Equal(got, want) {
+		t.Errorf("\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestHandleError(t *testing.T) {
+	ve := &validationError{}
+	ve.add("to", "must not be before from (%d)", 200)
+	rw := httptest.NewRecorder()
+	handleError(ve, http.StatusBadRequest, rw)
+
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("status %d", rw.Code)
+	}
+	var resp struct {
+		Error  string       `json:"error"`
+		Fields []FieldError `json:"fields"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if resp.Error != "invalid request: to: must not be before from (200)" || len(resp.Fields) != 1 || resp.Fields[0].Field != "to" {
+		t.Errorf("response %+v", resp)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// This file implements the checks applied to written lines before they are
+// passed on to the memory store: the unknown-metrics policy and the range and
+// scopes of the metrics section.
+
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
+)
+
+// lineScope returns the scope a line with the type and stype tags is
+// written at.
+func lineScope(typ, stype string) string {
+	switch {
+	case stype != "":
+		return stype
+	case typ != "":
+		return typ
+	default:
+		return "node"
+	}
+}
+
+// checkSample applies the range and scopes of the metrics section to a
+// sample of metric written at scope and counts the rejected ones.
+func checkSample(endpoint, metric, scope string, value float64) error {
+	s, ok := config.GetMetricSchema(metric)
+	if !ok {
+		return nil
+	}
+	if err := s.Check(value, scope); err != nil {
+		reason := "range"
+		if errors.Is(err, config.ErrScopeNotAllowed) {
+			reason = "scope"
+		}
+		writeInvalidValues.inc(endpoint, reason)
+		return fmt.Errorf("metric '%s': %w", metric, err)
+	}
+	return nil
+}
+
+// writeFiltered reports whether written lines have to go through a
+// writeFilter.
+func writeFiltered() bool {
+	if policy := config.Keys.Unknown.Policy; policy != "" && policy != "drop" {
+		return true
+	}
+	return config.ChecksValues()
+}
+
+// writeFilter applies the unknown-metrics policy and the value checks of the
+// metrics section (see checkSample) to a line-protocol stream read by
+// metricstore.DecodeLine. Lines of known metrics without checks are passed
+// on unchanged, only the measurement is looked at; the measurement of
+// registered metrics is replaced by their slot. Lines longer than the buffer
+// of the bufio.Reader are passed on in several chunks.
+type writeFilter struct {
+	r        *bufio.Reader
+	ms       *metricstore.MemoryStore
+	endpoint string
+	cluster  string
+	pending  []byte
+	midLine  bool // The last chunk did not end with a newline
+	skip     bool // Drop the rest of the current line
+	err      error
+	// Set if a line was rejected by the 'reject' policy. DecodeLine does
+	// not report read errors, so it has to be checked after decoding.
+	rejected error
+}
+
+func newWriteFilter(r io.Reader, ms *metricstore.MemoryStore, endpoint, cluster string) *writeFilter {
+	return &writeFilter{r: bufio.NewReaderSize(r, 64*1024), ms: ms, endpoint: endpoint, cluster: cluster}
+}
+
+func (f *writeFilter) Read(p []byte) (int, error) {
+	for len(f.pending) == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+
+		chunk, err := f.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			err = nil
+		}
+		f.err = err
+
+		slot := ""
+		if !f.midLine {
+			keep, s, err := f.checkLine(chunk)
+			if err != nil {
+				f.rejected, f.err = err, err
+				return 0, err
+			}
+			f.skip, slot = !keep, s
+		}
+		f.midLine = len(chunk) > 0 && chunk[len(chunk)-1] != '\n'
+		switch {
+		case f.skip:
+		case slot != "":
+			line := bytes.TrimLeft(chunk, " \t\r\n")
+			f.pending = append([]byte(slot), line[len(lineMeasurement(line)):]...)
+		default:
+			f.pending = chunk
+		}
+	}
+
+	n := copy(p, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+// checkLine returns whether the line starting with chunk is passed on and,
+// for registered metrics, the slot that replaces its measurement.
+func (f *writeFilter) checkLine(chunk []byte) (bool, string, error) {
+	line := bytes.TrimLeft(chunk, " \t\r\n")
+	if len(line) == 0 || line[0] == '#' {
+		return true, "", nil
+	}
+
+	name := string(lineMeasurement(line))
+	if _, ok := metricConfig(f.ms, name); !ok {
+		// The raw measurement may contain escapes.
+		var cluster, host string
+		var err error
+		name, cluster, host, err = lineSource(line, f.cluster)
+		if err != nil {
+			// Let DecodeLine report the syntax error.
+			return true, "", nil
+		}
+		if _, ok := metricConfig(f.ms, name); !ok {
+			if keep, err := unknownMetric(f.endpoint, name, cluster, host, 1); !keep || err != nil {
+				return false, "", err
+			}
+		}
+	}
+
+	if !f.checkValue(name, line) {
+		return false, "", nil
+	}
+	if slot := config.MetricSlot(name); slot != name {
+		return true, slot, nil
+	}
+	return true, "", nil
+}
+
+// checkValue returns whether the line of the known metric passes the value
+// checks. Lines that cannot be decoded are passed on, so that DecodeLine
+// reports the error.
+func (f *writeFilter) checkValue(metric string, line []byte) bool {
+	if s, ok := config.GetMetricSchema(metric); !ok || !s.ChecksValues() {
+		return true
+	}
+	cl, err := checkLine(bytes.TrimRight(line, "\r\n"), f.cluster)
+	if err != nil {
+		return true
+	}
+	if err := checkSample(f.endpoint, cl.metric, cl.scope, cl.value); err != nil {
+		cclog.Debugf("%s: dropping line of host '%s': %s", f.endpoint, cl.host, err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
+)
+
+// setupWriteFilter configures the metrics section and the unknown-metrics
+// policy and returns a memory store with the resulting metrics. The global
+// configuration is reset when the test ends, the unknown metrics seen so far
+// are forgotten.
+func setupWriteFilter(t *testing.T, unknown config.UnknownMetricsConfig) *metricstore.MemoryStore {
+	t.Helper()
+	if unknown.RegistryFile == "" {
+		unknown.RegistryFile = filepath.Join(t.TempDir(), "registered-metrics.json")
+	}
+	config.Keys.Unknown = unknown
+	unknownMetrics = &unknownMetricTracker{metrics: map[string]*UnknownMetric{}}
+	config.InitMetrics(json.RawMessage(`{
+		"cpu_load": { "frequency": 60, "aggregation": null, "min": 0, "max": 100 },
+		"mem_used": { "frequency": 60, "aggregation": "sum", "scopes": ["node"] }
+	}`))
+	config.InitRegistry()
+
+	t.Cleanup(func() {
+		config.Keys.Unknown = config.UnknownMetricsConfig{}
+		config.InitMetrics(json.RawMessage(`{}`))
+		config.InitRegistry()
+	})
+	return &metricstore.MemoryStore{Metrics: config.GetMetrics()}
+}
+
+func filterLines(t *testing.T, ms *metricstore.MemoryStore, input string) (string, *writeFilter) {
+	t.Helper()
+	f := newWriteFilter(strings.NewReader(input), ms, "write", "fritz")
+	out, err := io.ReadAll(f)
+	if err != nil && f.rejected == nil {
+		t.Fatalf("reading filter: %v", err)
+	}
+	return string(out), f
+}
+
+func TestWriteFilterChecks(t *testing.T) {
+	ms := setupWriteFilter(t, config.UnknownMetricsConfig{Policy: "count"})
+	if !writeFiltered() {
+		t.Fatal("writeFiltered() = false")
+	}
+
+	input := "cpu_load,hostname=f0101 value=1.5 1700000000\n" +
+		"# comment\n" +
+		"cpu_load,hostname=f0101 value=101 1700000060\n" +
+		"mem_used,hostname=f0101,type=socket,type-id=0 value=10 1700000000\n" +
+		"mem_used,hostname=f0101 value=10 1700000000\n" +
+		"mem_foo,hostname=f0102 value=1 1700000000\n" +
+		"cpu_load,hostname=f0101 value=2.5 1700000120"
+	want := "cpu_load,hostname=f0101 value=1.5 1700000000\n" +
+		"# comment\n" +
+		"mem_used,hostname=f0101 value=10 1700000000\n" +
+		"cpu_load,hostname=f0101 value=2.5 1700000120"
+
+	out, f := filterLines(t, ms, input)
+	if out != want {
+		t.Errorf("output\n%s\nwant\n%s", out, want)
+	}
+	if f.rejected != nil {
+		t.Errorf("rejected: %v", f.rejected)
+	}
+
+	found := false
+	for _, um := range unknownMetrics.list() {
+		if um.Name == "mem_foo" {
+			found = len(um.Hosts) == 1 && um.Hosts[0].Cluster == "fritz" && um.Hosts[0].Host == "f0102"
+		}
+	}
+	if !found {
+		t.Error("mem_foo not recorded as unknown metric of fritz/f0102")
+	}
+}
+
+func TestWriteFilterReject(t *testing.T) {
+	ms := setupWriteFilter(t, config.UnknownMetricsConfig{Policy: "reject"})
+
+	input := "cpu_load,hostname=f0101 value=1 1700000000\n" +
+		"mem_bar,hostname=f0101 value=1 1700000000\n" +
+		"cpu_load,hostname=f0101 value=2 1700000060\n"
+	out, f := filterLines(t, ms, input)
+	if out != "cpu_load,hostname=f0101 value=1 1700000000\n" {
+		t.Errorf("output %q", out)
+	}
+	if f.rejected == nil || f.rejected.Error() != "unknown metric 'mem_bar'" {
+		t.Errorf("rejected: %v", f.rejected)
+	}
+}
+
+func TestWriteFilterRegister(t *testing.T) {
+	ms := setupWriteFilter(t, config.UnknownMetricsConfig{Policy: "register", Frequency: 30, Aggregation: "avg", MaxRegistered: 1})
+	if _, ok := ms.Metrics["__ccms_reserved_000"]; !ok || len(ms.Metrics) != 3 {
+		t.Fatalf("reserved slots missing: %v", ms.Metrics)
+	}
+
+	input := "mem_foo,hostname=f0101 value=1 1700000000\n" +
+		"mem_bar,hostname=f0101 value=1 1700000000\n" +
+		"__ccms_reserved_000,hostname=f0101 value=1 1700000000\n" +
+		"  mem_foo,hostname=f0101 value=2 1700000030\n"
+	want := "__ccms_reserved_000,hostname=f0101 value=1 1700000000\n" +
+		"__ccms_reserved_000,hostname=f0101 value=2 1700000030\n"
+	out, _ := filterLines(t, ms, input)
+	if out != want {
+		t.Errorf("output\n%s\nwant\n%s", out, want)
+	}
+
+	if !config.IsRegistered("mem_foo") || config.IsRegistered("mem_bar") {
+		t.Errorf("registered: mem_foo %v, mem_bar %v", config.IsRegistered("mem_foo"), config.IsRegistered("mem_bar"))
+	}
+	if mc, ok := metricConfig(ms, "mem_foo"); !ok || mc.Frequency != 30 {
+		t.Errorf("metricConfig(mem_foo) = %v, %v", mc, ok)
+	}
+	if _, ok := metricConfig(ms, "__ccms_reserved_000"); ok {
+		t.Error("slot is visible by its own name")
+	}
+	if _, ok := metricConfigs(ms)["mem_foo"]; !ok {
+		t.Errorf("metricConfigs: %v", metricConfigs(ms))
+	}
+
+	// The assignment survives a restart.
+	raw, err := os.ReadFile(config.Keys.Unknown.RegistryFile)
+	if err != nil {
+		t.Fatalf("reading registry file: %v", err)
+	}
+	var reg struct {
+		Metrics map[string]string `json:"metrics"`
+	}
+	if err := json.Unmarshal(raw, &reg); err != nil || reg.Metrics["mem_foo"] != "__ccms_reserved_000" {
+		t.Errorf("registry file %s (%v)", raw, err)
+	}
+	config.InitRegistry()
+	if config.MetricSlot("mem_foo") != "__ccms_reserved_000" || config.MetricName("__ccms_reserved_000") != "mem_foo" {
+		t.Error("registration lost after reloading the registry file")
+	}
+}
+
+func TestWriteFilterLongLine(t *testing.T) {
+	ms := setupWriteFilter(t, config.UnknownMetricsConfig{Policy: "count"})
+
+	// Longer than the buffer of the filter, so it is passed on in chunks.
+	long := "cpu_load,hostname=f0101,padding=" + strings.Repeat("x", 100*1024) + " value=1 1700000000\n"
+	input := long + "mem_foo,hostname=f0101 value=1 1700000000\n" + long
+	out, _ := filterLines(t, ms, input)
+	if out != long+long {
+		t.Errorf("output of %d bytes, want %d", len(out), 2*len(long))
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// This file implements `/api/write/?report=true`. Every line is checked on
+// its own before it is passed on to metricstore.DecodeLine, so bad lines can
+// be skipped and reported instead of aborting the whole request.
+
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	"github.com/ClusterCockpit/cc-line-protocol/v2/lineprotocol"
+	"github.com/ClusterCockpit/cc-metric-store/internal/config"
+)
+
+const (
+	// maxReportedLines limits the rejected lines listed in a WriteReport.
+	maxReportedLines = 100
+	// writeBatchSize is the number of checked lines passed on to
+	// metricstore.DecodeLine at once.
+	writeBatchSize = 1024
+)
+
+// RejectedLine is a line of a write request that was not stored.
+type RejectedLine struct {
+	Line   int    `json:"line"` // Line number, starting at 1
+	Reason string `json:"reason"`
+}
+
+// WriteReport summarizes a write request.
+type WriteReport struct {
+	// Lines written to the memory store
+	Accepted int `json:"accepted"`
+	// Number of lines that could not be decoded or were invalid
+	RejectedCount int `json:"rejected-count"`
+	// The first rejected lines
+	Rejected []RejectedLine `json:"rejected"`
+	// Number of lines per metric that is not configured. These lines are
+	// ignored by the memory store.
+	UnknownMetrics map[string]int `json:"unknown-metrics"`
+	// Number of samples older than an earlier sample of the same series in
+	// this request. They are stored, but overwrite data in the past.
+	OutOfOrder int `json:"out-of-order"`
+}
+
+func (wr *WriteReport) reject(line int, err error) {
+	wr.RejectedCount++
+	if len(wr.Rejected) >= maxReportedLines {
+		return
+	}
+
+	// Lines are decoded one by one, so the line number of a DecodeError is
+	// always 1.
+	reason := err.Error()
+	var decErr *lineprotocol.DecodeError
+	if errors.As(err, &decErr) {
+		reason = fmt.Sprintf("column %d: %s", decErr.Column, decErr.Err.Error())
+	}
+	wr.Rejected = append(wr.Rejected, RejectedLine{Line: line, Reason: reason})
+}
+
+// lineTime decodes the timestamp of a line, trying the same precisions as
+// metricstore.DecodeLine.
+func lineTime(dec *lineprotocol.Decoder) (int64, error) {
+	var err error
+	for _, prec := range []lineprotocol.Precision{lineprotocol.Second, lineprotocol.Millisecond, lineprotocol.Microsecond, lineprotocol.Nanosecond} {
+		var t time.Time
+		if t, err = dec.Time(prec, time.Now()); err == nil {
+			return t.Unix(), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid timestamp: %w", err)
+}
+
+// checkedLine is a line decoded by checkLine.
+type checkedLine struct {
+	metric, cluster, host string
+	scope                 string // node or the stype or type tag
+	series                string // Identifies the series of the sample
+	value                 float64
+	ts                    int64
+}
+
+// checkLine decodes a single line like metricstore.DecodeLine would.
+func checkLine(line []byte, cluster string) (*checkedLine, error) {
+	dec := lineprotocol.NewDecoderWithBytes(line)
+	if !dec.Next() {
+		return nil, errors.New("empty line")
+	}
+
+	m, err := dec.Measurement()
+	if err != nil {
+		return nil, err
+	}
+	metric := string(m)
+
+	host, typ, typeID, stype, stypeID := "", "", "", "", ""
+	for {
+		key, val, err := dec.NextTag()
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			break
+		}
+		switch string(key) {
+		case "cluster":
+			cluster = string(val)
+		case "hostname", "host":
+			host = string(val)
+		case "type":
+			typ = string(val)
+		case "type-id":
+			typeID = string(val)
+		case "stype":
+			stype = string(val)
+		case "stype-id":
+			stypeID = string(val)
+		}
+	}
+	for _, c := range []string{cluster, host} {
+		if strings.Contains(c, "..") || strings.ContainsAny(c, "/\\") {
+			return nil, fmt.Errorf("invalid cluster or host tag %q", c)
+		}
+	}
+
+	fields, value := 0, 0.0
+	for {
+		key, val, err := dec.NextField()
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			break
+		}
+		if string(key) != "value" {
+			return nil, fmt.Errorf("unknown field '%s'", string(key))
+		}
+		switch val.Kind() {
+		case lineprotocol.Float:
+			value = val.FloatV()
+		case lineprotocol.Int:
+			value = float64(val.IntV())
+		case lineprotocol.Uint:
+			value = float64(val.UintV())
+		default:
+			return nil, fmt.Errorf("unsupported value type %s", val.Kind().String())
+		}
+		fields++
+	}
+	if fields == 0 {
+		return nil, errors.New("missing field 'value'")
+	}
+
+	ts, err := lineTime(dec)
+	if err != nil {
+		return nil, err
+	}
+	if dec.Next() {
+		return nil, errors.New("more than one line")
+	}
+
+	return &checkedLine{
+		metric:  metric,
+		cluster: cluster,
+		host:    host,
+		scope:   lineScope(typ, stype),
+		series:  strings.Join([]string{metric, cluster, host, typ, typeID, stype, stypeID}, "\x00"),
+		value:   value,
+		ts:      ts,
+	}, nil
+}
+
+// writeWithReport writes the lines of body to ms and skips the lines that
+// would make metricstore.DecodeLine fail. The lines are passed on in
+// batches, so memory usage does not depend on the size of the request.
+func writeWithReport(body io.Reader, ms *metricstore.MemoryStore, cluster string) (*WriteReport, error) {
+	report := &WriteReport{Rejected: []RejectedLine{}, UnknownMetrics: map[string]int{}}
+	latest := map[string]int64{}
+	batch, batchLines := &bytes.Buffer{}, 0
+
+	flush := func() error {
+		if batchLines == 0 {
+			return nil
+		}
+		dec := lineprotocol.NewDecoderWithBytes(batch.Bytes())
+		if err := metricstore.DecodeLine(dec, ms, cluster); err != nil {
+			return err
+		}
+		report.Accepted += batchLines
+		batch.Reset()
+		batchLines = 0
+		return nil
+	}
+
+	// handle checks the line with number n and adds it to the batch.
+	handle := func(n int, line []byte) error {
+		cl, err := checkLine(line, cluster)
+		if err == nil {
+			if _, ok := metricConfig(ms, cl.metric); !ok {
+				var register bool
+				if register, err = unknownMetric("write", cl.metric, cl.cluster, cl.host, 1); err == nil && !register {
+					report.UnknownMetrics[cl.metric]++
+					return nil
+				}
+			}
+		}
+		if err == nil {
+			err = checkSample("write", cl.metric, cl.scope, cl.value)
+		}
+		if err != nil {
+			report.reject(n, err)
+			return nil
+		}
+
+		if prev, ok := latest[cl.series]; ok && cl.ts < prev {
+			report.OutOfOrder++
+		} else {
+			latest[cl.series] = cl.ts
+		}
+		if slot := config.MetricSlot(cl.metric); slot != cl.metric {
+			batch.WriteString(slot)
+			line = line[len(lineMeasurement(line)):]
+		}
+		batch.Write(line)
+		batch.WriteByte('\n')
+		if batchLines++; batchLines >= writeBatchSize {
+			return flush()
+		}
+		return nil
+	}
+
+	br := bufio.NewReader(body)
+	n := 0
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			n++
+			trimmed := bytes.TrimSpace(line)
+			if len(trimmed) > 0 && trimmed[0] != '#' {
+				if err := handle(n, trimmed); err != nil {
+					return report, err
+				}
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, flush()
+}
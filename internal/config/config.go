@@ -9,6 +9,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
 	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
@@ -16,18 +17,90 @@ import (
 
 var metrics map[string]metricstore.MetricConfig
 
+// PrometheusWriteConfig controls how Prometheus remote-write samples are
+// mapped onto the metric store hierarchy.
+type PrometheusWriteConfig struct {
+	// Maps a hierarchy tag (cluster, hostname, type, type-id, stype,
+	// stype-id) to the Prometheus label holding its value.
+	Labels map[string]string `json:"labels"`
+	// Renames Prometheus metric names (__name__) to metric store names.
+	MetricNames map[string]string `json:"metric-names"`
+	// Deprecated: replaced by the unknown-metrics section, which applies to
+	// all write paths. "reject" is used as its policy if none is set.
+	UnknownMetrics string `json:"unknown-metrics"`
+}
+
+// QueryLimitsConfig bounds the cost of a single query request. Zero values
+// disable the respective limit.
+type QueryLimitsConfig struct {
+	MaxSelectors int    `json:"max-selectors"`
+	MaxPoints    int64  `json:"max-points"`
+	MaxTimeRange string `json:"max-time-range"`
+	MaxBodySize  int64  `json:"max-body-size"`
+}
+
+// UnknownMetricsConfig selects what happens to written lines of metrics
+// missing in the metrics section.
+type UnknownMetricsConfig struct {
+	// "drop" (default), "count", "reject" or "register"
+	Policy string `json:"policy"`
+	// Frequency and aggregation of auto-registered metrics.
+	Frequency   int64  `json:"frequency"`
+	Aggregation string `json:"aggregation"`
+	// Number of metric slots reserved for auto-registration.
+	MaxRegistered int `json:"max-registered"`
+	// File keeping the registered metrics across restarts.
+	RegistryFile string `json:"registry-file"`
+}
+
+// NodeProviderConfig selects a source of the nodes used by running jobs,
+// whose data is kept beyond the retention time.
+type NodeProviderConfig struct {
+	// "backend", "static" or "slurm"
+	Type string `json:"type"`
+	// URL of cc-backend (backend)
+	URL string `json:"url"`
+	// File to read (static, slurm). It is read again when it changes.
+	File string `json:"file"`
+	// Cluster of Slurm jobs without a cluster field (slurm)
+	Cluster string `json:"cluster"`
+	// Bearer token (a JWT of cc-backend) or a file holding it (backend)
+	Token     string `json:"token"`
+	TokenFile string `json:"token-file"`
+	// PEM file with the CA certificates of cc-backend (backend)
+	CAFile string `json:"ca-file"`
+	// Timeout of a request, retries with exponential backoff starting at
+	// retry-delay, the time all attempts together may take, and how long
+	// the last answer may be used if all retries fail (backend)
+	Timeout      string `json:"timeout"`
+	Retries      *int   `json:"retries"`
+	RetryDelay   string `json:"retry-delay"`
+	TotalTimeout string `json:"total-timeout"`
+	MaxStale     string `json:"max-stale"`
+}
+
 type Config struct {
-	Address    string `json:"addr"`
-	CertFile   string `json:"https-cert-file"`
-	KeyFile    string `json:"https-key-file"`
-	User       string `json:"user"`
-	Group      string `json:"group"`
+	Address  string `json:"addr"`
+	CertFile string `json:"https-cert-file"`
+	KeyFile  string `json:"https-key-file"`
+	User     string `json:"user"`
+	Group    string `json:"group"`
+	// Deprecated: replaced by a node-providers entry of type backend, which
+	// also takes a token, CA certificates and retry settings. Init adds an
+	// entry with the default settings for it.
 	BackendURL string `json:"backend-url"`
 	Debug      struct {
 		DumpToFile string `json:"dump-to-file"`
 		EnableGops bool   `json:"gops"`
 	} `json:"debug"`
-	JwtPublicKey string `json:"jwt-public-key"`
+	JwtPublicKey  string                `json:"jwt-public-key"`
+	RouteRoles    map[string][]string   `json:"route-roles"`
+	PublicMetrics bool                  `json:"public-metrics"`
+	QueryWorkers  int                   `json:"query-workers"`
+	QueryLimits   QueryLimitsConfig     `json:"query-limits"`
+	PromWrite     PrometheusWriteConfig `json:"prometheus-write"`
+	Unknown       UnknownMetricsConfig  `json:"unknown-metrics"`
+	NodeProviders []NodeProviderConfig  `json:"node-providers"`
 }
 
 var Keys Config
@@ -35,6 +108,11 @@ var Keys Config
 type metricConfigJSON struct {
 	Frequency   int64  `json:"frequency"`
 	Aggregation string `json:"aggregation"`
+	// Optional, see MetricSchema
+	Unit   string   `json:"unit"`
+	Min    *float64 `json:"min"`
+	Max    *float64 `json:"max"`
+	Scopes []string `json:"scopes"`
 }
 
 func InitMetrics(metricConfig json.RawMessage) {
@@ -48,6 +126,7 @@ func InitMetrics(metricConfig json.RawMessage) {
 	}
 
 	metrics = make(map[string]metricstore.MetricConfig)
+	t := &metricTables{schemas: map[string]MetricSchema{}}
 	for name, cfg := range tempMetrics {
 		agg, err := metricstore.AssignAggregationStrategy(cfg.Aggregation)
 		if err != nil {
@@ -57,7 +136,16 @@ func InitMetrics(metricConfig json.RawMessage) {
 			Frequency:   cfg.Frequency,
 			Aggregation: agg,
 		}
+		ms, err := newMetricSchema(name, cfg)
+		if err != nil {
+			cclog.Abortf("Config Init: %s\n", err.Error())
+		}
+		if ms.Unit != "" || ms.ChecksValues() {
+			t.schemas[name] = ms
+		}
 	}
+	tables.Store(t)
+	reserveMetricSlots()
 }
 
 func Init(mainConfig json.RawMessage) {
@@ -67,6 +155,29 @@ func Init(mainConfig json.RawMessage) {
 	if err := dec.Decode(&Keys); err != nil {
 		cclog.Abortf("Config Init: Could not decode config file '%s'.\nError: %s\n", mainConfig, err.Error())
 	}
+	if Keys.QueryLimits.MaxTimeRange != "" {
+		if _, err := time.ParseDuration(Keys.QueryLimits.MaxTimeRange); err != nil {
+			cclog.Abortf("Config Init: Could not parse query-limits.max-time-range '%s'.\nError: %s\n", Keys.QueryLimits.MaxTimeRange, err.Error())
+		}
+	}
+	if Keys.PromWrite.UnknownMetrics != "" {
+		cclog.Warn("Config Init: prometheus-write.unknown-metrics is deprecated, use unknown-metrics.policy instead.")
+		if Keys.PromWrite.UnknownMetrics == "reject" && Keys.Unknown.Policy == "" {
+			Keys.Unknown.Policy = "reject"
+		}
+	}
+	if Keys.BackendURL != "" {
+		cclog.Warn("Config Init: backend-url is deprecated, use a node-providers entry of type 'backend' instead.")
+		Keys.NodeProviders = append([]NodeProviderConfig{{Type: "backend", URL: Keys.BackendURL}}, Keys.NodeProviders...)
+	}
+	if Keys.Unknown.Policy == "register" {
+		if Keys.Unknown.Frequency <= 0 {
+			cclog.Abortf("Config Init: unknown-metrics.frequency is required for the 'register' policy.\n")
+		}
+		if _, err := metricstore.AssignAggregationStrategy(Keys.Unknown.Aggregation); err != nil {
+			cclog.Abortf("Config Init: Could not parse unknown-metrics.aggregation '%s'.\nError: %s\n", Keys.Unknown.Aggregation, err.Error())
+		}
+	}
 }
 
 func GetMetricFrequency(metricName string) (int64, error) {
@@ -19,6 +19,26 @@ var metricConfigSchema = `
       "aggregation": {
         "description": "Aggregation strategy: 'sum', 'avg', or 'null'.",
         "type": ["string", "null"]
+      },
+      "unit": {
+        "description": "Unit of the values, e.g. 'GHz' or 'MB/s'. Returned with query results.",
+        "type": "string"
+      },
+      "min": {
+        "description": "Smallest plausible value. Smaller written values are rejected.",
+        "type": "number"
+      },
+      "max": {
+        "description": "Largest plausible value. Larger written values are rejected.",
+        "type": "number"
+      },
+      "scopes": {
+        "description": "Scopes the metric may be written at. Values at other scopes are rejected.",
+        "type": "array",
+        "items": {
+          "type": "string",
+          "enum": ["node", "socket", "memoryDomain", "core", "hwthread", "accelerator"]
+        }
       }
     },
     "required": ["frequency", "aggregation"]
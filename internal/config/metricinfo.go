@@ -0,0 +1,119 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ClusterCockpit/cc-lib/v2/schema"
+)
+
+var (
+	ErrOutOfRange      = errors.New("value out of range")
+	ErrScopeNotAllowed = errors.New("scope not allowed")
+)
+
+// Scopes are the values of `scopes` in the metrics section. A line without a
+// type tag is written at node scope, otherwise at the scope named by the
+// stype or type tag.
+var Scopes = []string{
+	string(schema.MetricScopeNode),
+	string(schema.MetricScopeSocket),
+	string(schema.MetricScopeMemoryDomain),
+	string(schema.MetricScopeCore),
+	string(schema.MetricScopeHWThread),
+	string(schema.MetricScopeAccelerator),
+}
+
+// MetricSchema holds the optional unit, plausible range and allowed scopes
+// of a metric. Written values outside the range or at other scopes are
+// rejected.
+type MetricSchema struct {
+	Unit   string   `json:"unit,omitempty"`
+	Min    *float64 `json:"min,omitempty"`
+	Max    *float64 `json:"max,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+func newMetricSchema(name string, cfg metricConfigJSON) (MetricSchema, error) {
+	s := MetricSchema{Unit: cfg.Unit, Min: cfg.Min, Max: cfg.Max, Scopes: slices.Clone(cfg.Scopes)}
+	if s.Min != nil && s.Max != nil && *s.Min > *s.Max {
+		return s, fmt.Errorf("metric '%s': min %g is larger than max %g", name, *s.Min, *s.Max)
+	}
+	slices.Sort(s.Scopes)
+	s.Scopes = slices.Compact(s.Scopes)
+	return s, nil
+}
+
+// GetMetricSchema returns the schema of the metric name. The second return
+// value is false if the metric has none of unit, min, max and scopes.
+func GetMetricSchema(name string) (MetricSchema, bool) {
+	s, ok := loadTables().schemas[name]
+	return s, ok
+}
+
+// Check returns an error wrapping ErrOutOfRange or ErrScopeNotAllowed if
+// value must not be written at scope.
+func (s *MetricSchema) Check(value float64, scope string) error {
+	if s.Min != nil && value < *s.Min {
+		return fmt.Errorf("%w: %g is below min %g", ErrOutOfRange, value, *s.Min)
+	}
+	if s.Max != nil && value > *s.Max {
+		return fmt.Errorf("%w: %g is above max %g", ErrOutOfRange, value, *s.Max)
+	}
+	if len(s.Scopes) > 0 && !slices.Contains(s.Scopes, scope) {
+		return fmt.Errorf("%w: %s (allowed: %s)", ErrScopeNotAllowed, scope, strings.Join(s.Scopes, ", "))
+	}
+	return nil
+}
+
+// ChecksValues reports whether Check can reject a value.
+func (s *MetricSchema) ChecksValues() bool {
+	return s.Min != nil || s.Max != nil || len(s.Scopes) > 0
+}
+
+// schemaChanges describes the differences between the schemas of a metric
+// for ReloadMetrics.
+func schemaChanges(name string, old, updated MetricSchema) []string {
+	bound := func(v *float64) string {
+		if v == nil {
+			return "none"
+		}
+		return fmt.Sprintf("%g", *v)
+	}
+	scopes := func(s []string) string {
+		if len(s) == 0 {
+			return "all"
+		}
+		return strings.Join(s, ",")
+	}
+
+	changes := []string{}
+	if old.Unit != updated.Unit {
+		changes = append(changes, fmt.Sprintf("metric '%s': unit '%s' -> '%s'", name, old.Unit, updated.Unit))
+	}
+	if bound(old.Min) != bound(updated.Min) || bound(old.Max) != bound(updated.Max) {
+		changes = append(changes, fmt.Sprintf("metric '%s': range [%s, %s] -> [%s, %s]",
+			name, bound(old.Min), bound(old.Max), bound(updated.Min), bound(updated.Max)))
+	}
+	if scopes(old.Scopes) != scopes(updated.Scopes) {
+		changes = append(changes, fmt.Sprintf("metric '%s': scopes %s -> %s", name, scopes(old.Scopes), scopes(updated.Scopes)))
+	}
+	return changes
+}
+
+// ChecksValues reports whether any metric has a range or scopes.
+func ChecksValues() bool {
+	for _, s := range loadTables().schemas {
+		if s.ChecksValues() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,254 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+)
+
+const (
+	// defaultMaxRegistered is the number of metric slots reserved for the
+	// 'register' policy if unknown-metrics.max-registered is not set.
+	defaultMaxRegistered = 32
+	// defaultRegistryFile is used if unknown-metrics.registry-file is not
+	// set.
+	defaultRegistryFile = "./var/registered-metrics.json"
+)
+
+// reservedPrefix marks the names of reserved metric slots. The memory store,
+// its checkpoints and archives know a registered metric by the name of its
+// slot.
+const reservedPrefix = "__ccms_reserved_"
+
+// registry maps the metrics registered at runtime to their slots. It is
+// written to the registry file, so that the checkpointed data of a slot
+// stays with its metric across restarts. RegisterMetric publishes a new
+// value instead of modifying the current one, so it is read without a lock.
+type registry struct {
+	// Metric name to slot
+	Metrics map[string]string `json:"metrics"`
+	// Slots of registered metrics that were added to the metrics section
+	// since, with the time they were given up. They may still hold data of
+	// that metric, so they are only reused after retention-in-memory.
+	Retired map[string]int64 `json:"retired,omitempty"`
+
+	slots map[string]string // Slot to metric name
+}
+
+var registrations atomic.Pointer[registry]
+
+// loadRegistry returns the current registry.
+func loadRegistry() *registry {
+	if r := registrations.Load(); r != nil {
+		return r
+	}
+	return &registry{}
+}
+
+func (r *registry) clone() *registry {
+	c := &registry{Metrics: maps.Clone(r.Metrics), Retired: maps.Clone(r.Retired)}
+	if c.Metrics == nil {
+		c.Metrics = map[string]string{}
+	}
+	if c.Retired == nil {
+		c.Retired = map[string]int64{}
+	}
+	c.slots = make(map[string]string, len(c.Metrics))
+	for name, slot := range c.Metrics {
+		c.slots[slot] = name
+	}
+	return c
+}
+
+func registryFile() string {
+	if Keys.Unknown.RegistryFile != "" {
+		return Keys.Unknown.RegistryFile
+	}
+	return defaultRegistryFile
+}
+
+// save writes the registry to the registry file, replacing it atomically.
+func (r *registry) save() error {
+	raw, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := registryFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// IsReserved reports whether name is a metric slot reserved for
+// auto-registration, which must not be shown to users.
+func IsReserved(name string) bool {
+	return strings.HasPrefix(name, reservedPrefix)
+}
+
+// IsRegistered reports whether the metric name was added at runtime by
+// RegisterMetric.
+func IsRegistered(name string) bool {
+	_, ok := loadRegistry().Metrics[name]
+	return ok
+}
+
+// MetricSlot returns the name the metric name is stored under in the memory
+// store: the slot of a registered metric, otherwise name itself.
+func MetricSlot(name string) string {
+	if slot, ok := loadRegistry().Metrics[name]; ok {
+		return slot
+	}
+	return name
+}
+
+// MetricName is the inverse of MetricSlot. For unused slots, it returns the
+// slot itself.
+func MetricName(slot string) string {
+	if name, ok := loadRegistry().slots[slot]; ok {
+		return name
+	}
+	return slot
+}
+
+// reserveMetricSlots adds placeholder metrics for the 'register' policy of
+// unknown-metrics to the metric configuration. Every level of the memory
+// store has a fixed slot per metric and the memory store reads its metric
+// configuration without a lock, so metrics cannot be added after
+// metricstore.Init; instead RegisterMetric assigns a placeholder.
+func reserveMetricSlots() {
+	if Keys.Unknown.Policy != "register" {
+		return
+	}
+
+	n := Keys.Unknown.MaxRegistered
+	if n == 0 {
+		n = defaultMaxRegistered
+	}
+	agg, _ := metricstore.AssignAggregationStrategy(Keys.Unknown.Aggregation)
+	for i := range n {
+		metrics[fmt.Sprintf("%s%03d", reservedPrefix, i)] = metricstore.MetricConfig{
+			Frequency:   Keys.Unknown.Frequency,
+			Aggregation: agg,
+		}
+	}
+}
+
+// InitRegistry reads the metrics registered before the last restart from
+// the registry file. It has to be called after InitMetrics and
+// InitRetention. Registered metrics that were added to the metrics section
+// since give up their slot.
+func InitRegistry() {
+	r := &registry{}
+	if Keys.Unknown.Policy != "register" {
+		registrations.Store(r.clone())
+		return
+	}
+
+	raw, err := os.ReadFile(registryFile())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		cclog.Abortf("Config Init: Could not read registry file '%s'.\nError: %s\n", registryFile(), err.Error())
+	}
+	if err == nil {
+		if err := json.Unmarshal(raw, r); err != nil {
+			cclog.Abortf("Config Init: Could not decode registry file '%s'.\nError: %s\n", registryFile(), err.Error())
+		}
+	}
+	r = r.clone()
+
+	now := time.Now()
+	for name, slot := range r.Metrics {
+		switch {
+		case metrics[slot].Frequency == 0:
+			cclog.Warnf("Registered metric '%s' dropped, unknown-metrics.max-registered was lowered", name)
+			delete(r.Metrics, name)
+		case metrics[name].Frequency != 0:
+			cclog.Infof("Registered metric '%s' is configured in the metrics section now, its data stored so far is not carried over", name)
+			delete(r.Metrics, name)
+			r.Retired[slot] = now.Unix()
+		}
+	}
+	for slot, t := range r.Retired {
+		if metrics[slot].Frequency == 0 || (retentionInMemory > 0 && now.Sub(time.Unix(t, 0)) > retentionInMemory) {
+			delete(r.Retired, slot)
+		}
+	}
+	r = r.clone()
+
+	if err := r.save(); err != nil {
+		cclog.Abortf("Config Init: Could not write registry file '%s'.\nError: %s\n", registryFile(), err.Error())
+	}
+	registrations.Store(r)
+	if len(r.Metrics) > 0 {
+		cclog.Infof("Loaded %d registered metrics from '%s'", len(r.Metrics), registryFile())
+	}
+}
+
+// freeSlots returns the reserved slots that are neither assigned nor
+// retired, sorted by name.
+func (r *registry) freeSlots() []string {
+	free := []string{}
+	for slot := range metrics {
+		if _, used := r.slots[slot]; IsReserved(slot) && !used && r.Retired[slot] == 0 {
+			free = append(free, slot)
+		}
+	}
+	slices.Sort(free)
+	return free
+}
+
+// RegisterMetric assigns one of the slots reserved by reserveMetricSlots to
+// the metric name. It returns false if all slots are taken. Registered
+// metrics are kept in the registry file, not in the config file.
+func RegisterMetric(name string) bool {
+	if IsReserved(name) {
+		return false
+	}
+
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	current := loadRegistry()
+	if _, ok := current.Metrics[name]; ok {
+		return true
+	}
+
+	free := current.freeSlots()
+	if len(free) == 0 {
+		return false
+	}
+	slot := free[0]
+
+	updated := current.clone()
+	updated.Metrics[name] = slot
+	updated.slots[slot] = name
+	if err := updated.save(); err != nil {
+		cclog.Errorf("Could not register metric '%s': writing registry file failed: %s", name, err.Error())
+		return false
+	}
+	registrations.Store(updated)
+
+	cclog.Infof("Registered unknown metric '%s' (frequency %d, %d slots left)", name, metrics[slot].Frequency, len(free)-1)
+	return true
+}
@@ -0,0 +1,216 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ClusterCockpit/cc-backend/pkg/metricstore"
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+)
+
+// File is the path of the config file the server was started with. It is
+// read again by ReloadMetrics.
+var File string
+
+// reloadLock serializes ReloadMetrics and RegisterMetric.
+var reloadLock sync.Mutex
+
+// metricTables holds the settings of the metrics section that are kept by
+// this package instead of the memory store. ReloadMetrics publishes a new
+// value instead of modifying the current one, so readers load it without a
+// lock.
+type metricTables struct {
+	schemas map[string]MetricSchema
+}
+
+var tables atomic.Pointer[metricTables]
+
+// loadTables returns the current metric tables.
+func loadTables() *metricTables {
+	if t := tables.Load(); t != nil {
+		return t
+	}
+	return &metricTables{}
+}
+
+// MetricChanges reports the outcome of ReloadMetrics.
+type MetricChanges struct {
+	// Changes that were applied to the running memory store.
+	Applied []string `json:"applied"`
+	// Changes that only take effect after a restart.
+	RestartRequired []string `json:"restart-required"`
+}
+
+// AggregationName returns the name of agg as used in the metrics section.
+func AggregationName(agg metricstore.AggregationStrategy) string {
+	switch agg {
+	case metricstore.SumAggregation:
+		return "sum"
+	case metricstore.AvgAggregation:
+		return "avg"
+	default:
+		return "null"
+	}
+}
+
+// readMetricsSection reads the `metrics` section from the config file,
+// following a `metrics-file` reference like ccconfig.Init does.
+func readMetricsSection(filename string) (json.RawMessage, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &sections); err != nil {
+		return nil, fmt.Errorf("decoding config file '%s': %w", filename, err)
+	}
+
+	if section, ok := sections["metrics"]; ok {
+		return section, nil
+	}
+	if ref, ok := sections["metrics-file"]; ok {
+		var metricsFile string
+		if err := json.Unmarshal(ref, &metricsFile); err != nil {
+			return nil, fmt.Errorf("decoding metrics-file: %w", err)
+		}
+		return os.ReadFile(metricsFile)
+	}
+	return nil, fmt.Errorf("missing metrics configuration in '%s'", filename)
+}
+
+// ReloadMetrics re-reads the metric configuration from File and applies the
+// changes that are safe while running. These are changes of the schema
+// (unit, range and scopes), which is kept by this package, and added metrics with the frequency and aggregation of the slots
+// reserved for the 'register' policy of unknown-metrics, which are assigned
+// a free slot like RegisterMetric does. The memory store reads its metric
+// configuration without a lock, so ms.Metrics is never modified: other
+// added metrics, removed metrics and changed frequencies or aggregation
+// strategies are only reported and take effect after a restart. If the new
+// configuration is invalid, nothing is changed.
+func ReloadMetrics(ms *metricstore.MemoryStore) (*MetricChanges, error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	raw, err := readMetricsSection(File)
+	if err != nil {
+		return nil, err
+	}
+	if err := validate(metricConfigSchema, raw); err != nil {
+		return nil, err
+	}
+
+	var tempMetrics map[string]metricConfigJSON
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&tempMetrics); err != nil {
+		return nil, fmt.Errorf("decoding metrics configuration: %w", err)
+	}
+
+	changes := &MetricChanges{Applied: []string{}, RestartRequired: []string{}}
+	current := loadTables()
+	reg, assigned := loadRegistry().clone(), false
+
+	// Metrics missing in the file stay in the memory store until a restart,
+	// so they keep their schema.
+	updated := &metricTables{schemas: map[string]MetricSchema{}}
+	for name, s := range current.schemas {
+		if _, ok := tempMetrics[name]; !ok {
+			updated.schemas[name] = s
+		}
+	}
+
+	names := make([]string, 0, len(tempMetrics))
+	for name := range tempMetrics {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		cfg := tempMetrics[name]
+		agg, err := metricstore.AssignAggregationStrategy(cfg.Aggregation)
+		if err != nil {
+			return nil, fmt.Errorf("metric '%s': %w", name, err)
+		}
+
+		slot, ok := reg.Metrics[name]
+		if !ok {
+			slot = name
+		}
+		mc, ok := ms.Metrics[slot]
+		if !ok {
+			free := reg.freeSlots()
+			if len(free) == 0 || ms.Metrics[free[0]].Frequency != cfg.Frequency || ms.Metrics[free[0]].Aggregation != agg {
+				changes.RestartRequired = append(changes.RestartRequired,
+					fmt.Sprintf("metric '%s' added", name))
+				continue
+			}
+			slot, mc = free[0], ms.Metrics[free[0]]
+			reg.Metrics[name], reg.slots[slot] = slot, name
+			assigned = true
+			changes.Applied = append(changes.Applied,
+				fmt.Sprintf("metric '%s' added in slot %s", name, slot))
+		}
+		if mc.Frequency != cfg.Frequency {
+			changes.RestartRequired = append(changes.RestartRequired,
+				fmt.Sprintf("metric '%s': frequency %d -> %d", name, mc.Frequency, cfg.Frequency))
+		}
+		if mc.Aggregation != agg {
+			changes.RestartRequired = append(changes.RestartRequired,
+				fmt.Sprintf("metric '%s': aggregation %s -> %s", name, AggregationName(mc.Aggregation), AggregationName(agg)))
+		}
+		ns, err := newMetricSchema(name, cfg)
+		if err != nil {
+			return nil, err
+		}
+		changes.Applied = append(changes.Applied, schemaChanges(name, current.schemas[name], ns)...)
+		if ns.Unit != "" || ns.ChecksValues() {
+			updated.schemas[name] = ns
+		}
+	}
+
+	removed := []string{}
+	for name := range ms.Metrics {
+		// Reserved slots are not part of the file.
+		if IsReserved(name) {
+			continue
+		}
+		if _, ok := tempMetrics[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	slices.Sort(removed)
+	for _, name := range removed {
+		changes.RestartRequired = append(changes.RestartRequired,
+			fmt.Sprintf("metric '%s' removed", name))
+	}
+
+	if assigned {
+		if err := reg.save(); err != nil {
+			return nil, fmt.Errorf("writing registry file '%s': %w", registryFile(), err)
+		}
+		registrations.Store(reg)
+	}
+	if len(changes.Applied) > 0 {
+		tables.Store(updated)
+	}
+
+	for _, c := range changes.Applied {
+		cclog.Infof("Reload metrics: applied %s", c)
+	}
+	for _, c := range changes.RestartRequired {
+		cclog.Warnf("Reload metrics: restart required for %s", c)
+	}
+
+	return changes, nil
+}
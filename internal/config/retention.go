@@ -0,0 +1,90 @@
+// Copyright (C) NHR@FAU, University Erlangen-Nuremberg.
+// All rights reserved. This file is part of cc-metric-store.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	cclog "github.com/ClusterCockpit/cc-lib/v2/ccLogger"
+)
+
+// clusterRetentionKey is the key of the per-cluster retention in the
+// metric-store section. metricstore.Init rejects unknown keys, so it is
+// removed by InitRetention.
+const clusterRetentionKey = "retention-per-cluster"
+
+var (
+	// retentionInMemory is `retention-in-memory` of the metric-store
+	// section. The memory store frees all data older than that, so it is the
+	// upper bound of all overrides. 0 means data is never freed.
+	retentionInMemory time.Duration
+	clusterRetention  = map[string]time.Duration{}
+)
+
+// parseRetention parses the retention override value of what.
+func parseRetention(what, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", what, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s: retention must be positive", what)
+	}
+	if retentionInMemory > 0 && d > retentionInMemory {
+		return 0, fmt.Errorf("%s: retention %s exceeds retention-in-memory %s", what, d, retentionInMemory)
+	}
+	return d, nil
+}
+
+// InitRetention reads `retention-in-memory` and `retention-per-cluster` from
+// the metric-store section. It returns the section without
+// `retention-per-cluster` to be passed to metricstore.Init.
+func InitRetention(metricStoreConfig json.RawMessage) json.RawMessage {
+	var keys struct {
+		RetentionInMemory string            `json:"retention-in-memory"`
+		PerCluster        map[string]string `json:"retention-per-cluster"`
+	}
+	if err := json.Unmarshal(metricStoreConfig, &keys); err != nil {
+		cclog.Abortf("Config Init: Could not decode metric-store config '%s'.\nError: %s\n", metricStoreConfig, err.Error())
+	}
+	// metricstore.Init reports an invalid value itself.
+	retentionInMemory, _ = time.ParseDuration(keys.RetentionInMemory)
+
+	for cluster, value := range keys.PerCluster {
+		d, err := parseRetention(fmt.Sprintf("%s.%s", clusterRetentionKey, cluster), value)
+		if err != nil {
+			cclog.Abortf("Config Init: %s\n", err.Error())
+		}
+		clusterRetention[cluster] = d
+	}
+
+	if keys.PerCluster == nil {
+		return metricStoreConfig
+	}
+	return withoutKey(metricStoreConfig, clusterRetentionKey)
+}
+
+// ClusterRetention returns the clusters with a retention shorter than
+// `retention-in-memory`.
+func ClusterRetention() map[string]time.Duration {
+	return clusterRetention
+}
+
+// withoutKey returns the metric-store section without key.
+func withoutKey(metricStoreConfig json.RawMessage, key string) json.RawMessage {
+	var section map[string]json.RawMessage
+	if err := json.Unmarshal(metricStoreConfig, &section); err != nil {
+		cclog.Abortf("Config Init: Could not decode metric-store config '%s'.\nError: %s\n", metricStoreConfig, err.Error())
+	}
+	delete(section, key)
+	raw, err := json.Marshal(section)
+	if err != nil {
+		cclog.Abortf("Config Init: Could not encode metric-store config.\nError: %s\n", err.Error())
+	}
+	return raw
+}
@@ -30,9 +30,70 @@ var configSchema = `
       "type": "string"
     },
     "backend-url": {
-      "description": "URL of cc-backend for querying job information (e.g., 'https://localhost:8080').",
+      "description": "Deprecated: use a node-providers entry of type 'backend'. URL of cc-backend for querying job information (e.g., 'https://localhost:8080').",
       "type": "string"
     },
+    "node-providers": {
+      "description": "Sources of the nodes used by running jobs, whose data is kept when freeing buffers. Several providers are merged.",
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "type": {
+            "description": "'backend' (cc-backend), 'static' (JSON file of cluster to hosts) or 'slurm' (squeue/scontrol JSON dump).",
+            "type": "string",
+            "enum": ["backend", "static", "slurm"]
+          },
+          "url": {
+            "description": "URL of cc-backend (type 'backend').",
+            "type": "string"
+          },
+          "file": {
+            "description": "File to read (types 'static' and 'slurm'), read again when it changes.",
+            "type": "string"
+          },
+          "cluster": {
+            "description": "Cluster of Slurm jobs without a cluster field (type 'slurm').",
+            "type": "string"
+          },
+          "token": {
+            "description": "Bearer token (JWT) sent to cc-backend (type 'backend').",
+            "type": "string"
+          },
+          "token-file": {
+            "description": "File holding the bearer token, read again when it changes (type 'backend').",
+            "type": "string"
+          },
+          "ca-file": {
+            "description": "PEM file with CA certificates to trust in addition to the system ones (type 'backend').",
+            "type": "string"
+          },
+          "timeout": {
+            "description": "Timeout of a single request, e.g. '10s' (default) (type 'backend').",
+            "type": "string"
+          },
+          "retries": {
+            "description": "Number of retries of a failed request (default 3) (type 'backend').",
+            "type": "integer",
+            "minimum": 0
+          },
+          "retry-delay": {
+            "description": "Delay before the first retry, doubled for every further one, e.g. '1s' (default) (type 'backend').",
+            "type": "string"
+          },
+          "total-timeout": {
+            "description": "Time all attempts of a query together may take, e.g. '20s' (default) (type 'backend').",
+            "type": "string"
+          },
+          "max-stale": {
+            "description": "How long the last successful answer is used if cc-backend cannot be reached, e.g. '1h'. Disabled by default (type 'backend').",
+            "type": "string"
+          }
+        },
+        "required": ["type"],
+        "additionalProperties": false
+      }
+    },
     "debug": {
       "description": "Debug options.",
       "type": "object",
@@ -50,6 +111,123 @@ var configSchema = `
     "jwt-public-key": {
       "description": "Ed25519 public key for JWT verification.",
       "type": "string"
+    },
+    "route-roles": {
+      "description": "Roles (any of) the 'roles' claim of a JWT must contain to access an endpoint. Overrides the built-in defaults per endpoint; an empty list allows any valid token, including tokens without a 'roles' claim.",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "query": { "$ref": "#/$defs/roles" },
+        "write": { "$ref": "#/$defs/roles" },
+        "free": { "$ref": "#/$defs/roles" },
+        "debug": { "$ref": "#/$defs/roles" },
+        "healthcheck": { "$ref": "#/$defs/roles" },
+        "metrics": { "$ref": "#/$defs/roles" },
+        "reload": { "$ref": "#/$defs/roles" }
+      }
+    },
+    "public-metrics": {
+      "description": "Serve GET /metrics without authentication, so it can be scraped without a token.",
+      "type": "boolean"
+    },
+    "query-workers": {
+      "description": "Number of concurrent workers reading the selectors of a query request (0 = number of CPUs).",
+      "type": "integer",
+      "minimum": 0
+    },
+    "query-limits": {
+      "description": "Limits for a single query request. A value of 0 disables the limit.",
+      "type": "object",
+      "properties": {
+        "max-selectors": {
+          "description": "Maximum number of selectors a request may expand to (e.g. nodes x metrics for for-all-nodes).",
+          "type": "integer",
+          "minimum": 0
+        },
+        "max-points": {
+          "description": "Maximum number of data points a request may return.",
+          "type": "integer",
+          "minimum": 0
+        },
+        "max-time-range": {
+          "description": "Maximum time range (to - from) of a request, e.g. '48h'.",
+          "type": "string"
+        },
+        "max-body-size": {
+          "description": "Maximum size of the request body in bytes.",
+          "type": "integer",
+          "minimum": 0
+        }
+      },
+      "additionalProperties": false
+    },
+    "prometheus-write": {
+      "description": "Mapping of Prometheus remote-write samples onto the metric store hierarchy.",
+      "type": "object",
+      "properties": {
+        "labels": {
+          "description": "Prometheus label holding the value of a hierarchy tag. Defaults to the tag name with '-' replaced by '_'.",
+          "type": "object",
+          "additionalProperties": false,
+          "properties": {
+            "cluster": { "type": "string" },
+            "hostname": { "type": "string" },
+            "type": { "type": "string" },
+            "type-id": { "type": "string" },
+            "stype": { "type": "string" },
+            "stype-id": { "type": "string" }
+          }
+        },
+        "metric-names": {
+          "description": "Map of Prometheus metric names to metric store metric names.",
+          "type": "object",
+          "additionalProperties": { "type": "string" }
+        },
+        "unknown-metrics": {
+          "description": "Deprecated, use unknown-metrics.policy. 'reject' is used as the policy if none is set.",
+          "type": "string",
+          "enum": ["drop", "reject"]
+        }
+      }
+    },
+    "unknown-metrics": {
+      "description": "Handling of written lines for metrics missing in the metrics section.",
+      "type": "object",
+      "properties": {
+        "policy": {
+          "description": "'drop' (default, silently), 'count' (drop and list them at /api/unknown-metrics/), 'reject' the request or 'register' the metric.",
+          "type": "string",
+          "enum": ["drop", "count", "reject", "register"]
+        },
+        "frequency": {
+          "description": "Frequency in seconds of auto-registered metrics. Required for 'register'.",
+          "type": "integer",
+          "minimum": 1
+        },
+        "aggregation": {
+          "description": "Aggregation strategy of auto-registered metrics: 'sum', 'avg' or '' (none).",
+          "type": "string",
+          "enum": ["", "sum", "avg"]
+        },
+        "max-registered": {
+          "description": "Number of metrics that can be registered at runtime (default 32).",
+          "type": "integer",
+          "minimum": 0
+        },
+        "registry-file": {
+          "description": "File keeping the registered metrics across restarts (default ./var/registered-metrics.json).",
+          "type": "string"
+        }
+      },
+      "additionalProperties": false
+    }
+  },
+  "$defs": {
+    "roles": {
+      "type": "array",
+      "items": {
+        "type": "string"
+      }
     }
   }
 }`
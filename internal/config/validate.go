@@ -27,3 +27,19 @@ func Validate(schema string, instance json.RawMessage) {
 		cclog.Fatalf("%#v", err)
 	}
 }
+
+// validate is the variant of Validate that returns the error instead of
+// terminating, for configuration reloaded at runtime.
+func validate(schema string, instance json.RawMessage) error {
+	sch, err := jsonschema.CompileString("schema.json", schema)
+	if err != nil {
+		return err
+	}
+
+	var v any
+	if err := json.Unmarshal([]byte(instance), &v); err != nil {
+		return err
+	}
+
+	return sch.Validate(v)
+}